@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
@@ -11,10 +12,12 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
 	"github.com/rakutentech/cf-metrics-refinery/debug"
 	"github.com/rakutentech/cf-metrics-refinery/enricher"
+	"github.com/rakutentech/cf-metrics-refinery/filter"
 	"github.com/rakutentech/cf-metrics-refinery/input"
 	"github.com/rakutentech/cf-metrics-refinery/output"
 	"github.com/rakutentech/cf-metrics-refinery/transformer"
@@ -38,21 +41,43 @@ type CLI struct {
 	Conf                 *Config
 	//LogLevel string
 	Logger *log.Logger
+	// Filter selects the enriched envelopes to write; if nil, all envelopes
+	// are written.
+	Filter filter.Filter
+	// Transformer converts the envelopes to points; if nil, the default
+	// configuration is used.
+	Transformer *transformer.Transformer
+	// Validator checks the points before they are written; if nil, the
+	// points are not validated.
+	Validator *transformer.Validator
+	// Cardinality limits the number of series written; if nil, there is no
+	// limit.
+	Cardinality *transformer.CardinalityLimiter
 }
 
 // Config is the root configuration structure
 type Config struct {
-	CF       enricher.ConfigCF
-	InfluxDB output.ConfigInfluxDB
-	Batcher  output.ConfigBatcher
-	Kafka    input.ConfigKafka
-	Server   debug.ConfigServer
+	CF          enricher.ConfigCF
+	Foundations enricher.ConfigFoundations `desc:"JSON list of additional Cloud Foundry foundations"`
+	Ownership   enricher.ConfigOwnership
+	Filter      filter.ConfigFilter
+	Transformer transformer.ConfigTransformer
+	Validator   transformer.ConfigValidator
+	Cardinality transformer.ConfigCardinality
+	InfluxDB    output.ConfigInfluxDB
+	Batcher     output.ConfigBatcher
+	Aggregator  output.ConfigAggregator
+	Correlator  output.ConfigCorrelator
+	Kafka       input.ConfigKafka
+	Server      debug.ConfigServer
 
 	MetadataRefresh          time.Duration `default:"10m" desc:"How often to fetch a fresh copy of all metadata"`
 	MetadataExpire           time.Duration `default:"3m" desc:"How long before metadata is considered expired"`
 	MetadataExpireCheck      time.Duration `default:"1m" desc:"How often to check for expired metadata"`
 	NegativeCacheExpire      time.Duration `default:"20m" desc:"How long before negative cache is considered expired"`
 	NegativeCacheExpireCheck time.Duration `default:"3m" desc:"How often to check for expired negative cache"`
+	TrustEnvelopeTags        bool          `default:"false" desc:"Use the app metadata in the envelope tags, if present, instead of querying the CF API"`
+	PlatformEvents           bool          `default:"false" desc:"Write the metrics of platform components (envelopes without an app GUID)"`
 }
 
 // ConfigParse parses the CFMR_* environment vars to extract the configuration
@@ -97,23 +122,102 @@ func (cli *CLI) Run(logger *log.Logger) int {
 	srv := server.Start()
 	defer srv.Shutdown(nil)
 
-	// Build the enricher chain
+	// Load the ownership mapping
+	var ownership *enricher.OwnershipMapping
+	if cli.Conf.Ownership.File != "" {
+		ownership, err = enricher.NewOwnershipMapping(cli.Conf.Ownership.File)
+		if err != nil {
+			cli.Logger.Println("[ERROR] Failed to load the ownership mapping", err)
+			return ExitCodeError
+		}
+		go func() {
+			cli.OwnershipReload(ownership)
+		}()
+	}
+
+	// Build the enricher chain of each foundation
 	cli.Conf.CF.UserAgent = userAgent
-	cfclient, cache, negativeCache, err := cli.EnricherChain(stats)
+	primary, err := cli.EnricherChain(cli.Conf.CF, ownership, stats)
 	if err != nil {
 		cli.Logger.Println("[ERROR] Failed to build the enricher chain", err)
 		return ExitCodeError
 	}
+	foundations := []*Foundation{primary}
+	router := enricher.NewFoundationRouter(primary.Enricher)
+	for _, cfg := range cli.Conf.Foundations {
+		cfg.UserAgent = userAgent
+		f, err := cli.EnricherChain(cfg.ConfigCF, ownership, stats)
+		if err != nil {
+			cli.Logger.Printf("[ERROR] Failed to build the enricher chain for foundation %s: %v", cfg.Name, err)
+			return ExitCodeError
+		}
+		if err := router.Add(f.Enricher, cfg.Topics, cfg.Deployments); err != nil {
+			cli.Logger.Printf("[ERROR] Failed to route foundation %s: %v", cfg.Name, err)
+			return ExitCodeError
+		}
+		foundations = append(foundations, f)
+	}
+	if len(cli.Conf.Foundations) > 0 && !cli.Conf.Transformer.FoundationTag {
+		cli.Logger.Println("[WARN] Multiple foundations are configured, but the foundation tag is disabled: their points can't be told apart (CFMR_TRANSFORMER_FOUNDATIONTAG)")
+	}
 
 	// Initial warmup
-	cli.Logger.Print("[INFO] Warming up metadata cache")
-	start := time.Now()
-	appMetadataRunning, err := cfclient.GetRunningAppMetadata()
+	for _, f := range foundations {
+		cli.Logger.Printf("[INFO] Warming up metadata cache of foundation %s", f.Name)
+		start := time.Now()
+		appMetadataRunning, err := f.CFClient.GetRunningAppMetadata()
+		if err != nil {
+			cli.Logger.Println("[WARN] Failed to warmup metadata cache", err)
+		}
+		f.Cache.Warmup(appMetadataRunning)
+		cli.Logger.Printf("[INFO] Warming up metadata cache of foundation %s: %v", f.Name, time.Since(start))
+
+		if server.Admin != nil {
+			f := f
+			server.Admin.AddFoundation(f.Name, debug.AdminFoundation{
+				Cache:         f.Cache,
+				NegativeCache: f.NegativeCache,
+				Refresh: func() error {
+					return cli.RefreshCache(f.Cache, f.NegativeCache, f.CFClient)
+				},
+			})
+		}
+	}
+
+	// Build the filter chain
+	cli.Filter, err = cli.FilterChain()
+	if err != nil {
+		cli.Logger.Println("[ERROR] Failed to build the filter chain", err)
+		return ExitCodeError
+	}
+
+	// Build the transformer
+	cli.Transformer, err = cli.TransformerChain(stats)
 	if err != nil {
-		cli.Logger.Println("[WARN] Failed to warmup metadata cache", err)
+		cli.Logger.Println("[ERROR] Failed to build the transformer", err)
+		return ExitCodeError
+	}
+
+	// Build the validator
+	cli.Validator, err = cli.ValidatorChain(stats, server.Admin)
+	if err != nil {
+		cli.Logger.Println("[ERROR] Failed to build the validator", err)
+		return ExitCodeError
+	}
+
+	// Build the cardinality limiter
+	if cli.Conf.Cardinality.MaxSeries > 0 {
+		cli.Cardinality, err = transformer.NewCardinalityLimiter(cli.Conf.Cardinality, cli.Conf.InfluxDB.Tags, func(measurement, org string) {
+			stats.Inc(debug.CardinalityLimit, 1)
+		})
+		if err != nil {
+			cli.Logger.Println("[ERROR] Failed to build the cardinality limiter", err)
+			return ExitCodeError
+		}
+		server.AddReport("/stats/cardinality", func() interface{} {
+			return cli.Cardinality.Offenders()
+		})
 	}
-	cache.(*enricher.MemLRUCache).Warmup(appMetadataRunning)
-	cli.Logger.Printf("[INFO] Warming up metadata cache: %v", time.Since(start))
 
 	// Build the input chain
 	consumer, err := cli.InputChain()
@@ -127,24 +231,29 @@ func (cli *CLI) Run(logger *log.Logger) int {
 		cli.CGErrorsCheck(consumer)
 	}()
 
-	// Metadata cache eviction loop
-	go func() {
-		cli.CacheEvict(cache)
-	}()
+	for _, f := range foundations {
+		f := f
 
-	// Metadata cache refresh loop
-	go func() {
-		cli.CacheRefresh(cache, negativeCache, cfclient)
-	}()
+		// Metadata cache eviction loop
+		go func() {
+			cli.CacheEvict(f.Cache)
+		}()
 
-	// Negative cache eviction loop
-	go func() {
-		cli.NegativeCacheEvict(negativeCache)
-	}()
+		// Metadata cache refresh loop
+		go func() {
+			cli.CacheRefresh(f.Cache, f.NegativeCache, f.CFClient)
+		}()
+
+		// Negative cache eviction loop
+		go func() {
+			cli.NegativeCacheEvict(f.NegativeCache)
+		}()
+	}
 
 	// Build the output chain
 	cli.Logger.Println("[INFO] Configured InfluxDB, db:", cli.Conf.InfluxDB.Database)
 	cli.Conf.InfluxDB.UserAgent = userAgent
+	cli.Conf.InfluxDB.Collisions = cli.Conf.Transformer.Collisions
 	batcher, err := cli.OutputChain(consumer, stats)
 	if err != nil {
 		cli.Logger.Println("[ERROR] Failed to build the output chain", err)
@@ -157,11 +266,12 @@ func (cli *CLI) Run(logger *log.Logger) int {
 	}()
 
 	done := make(chan struct{}, 1)
+	stop := make(chan struct{})
 
 	// Main envelope processing loop
 	go func() {
 		cli.Logger.Println("[INFO] Started processing.")
-		if err := cli.Process(consumer, cache, batcher, stats); err != nil {
+		if err := cli.Process(consumer, router, batcher, stats, stop); err != nil {
 			cli.Logger.Println(err)
 		}
 
@@ -170,12 +280,19 @@ func (cli *CLI) Run(logger *log.Logger) int {
 
 	// Gracefully stop when receving a signal
 	go func() {
-		cli.TrapSignals(consumer)
+		cli.TrapSignals(stop)
 	}()
 
 	<-done
 	cli.Logger.Println("[INFO] Finished processing.")
 
+	// the output has been flushed and the offsets committed: the input can
+	// be closed
+	cli.Logger.Println("[INFO] Closing input")
+	if err := consumer.Close(); err != nil {
+		cli.Logger.Println("[ERROR] Failed to close input", err)
+	}
+
 	return ExitCodeOK
 }
 
@@ -187,18 +304,81 @@ func (_ *CLI) Version() string {
 	return version
 }
 
-func (cli *CLI) Process(consumer input.Reader, cache enricher.Enricher, batcher output.AsyncWriter, stats *debug.Stats) error {
+// Process reads, enriches, transforms and writes the envelopes until the
+// input fails or stop is closed. The output is flushed before returning, so
+// that the offsets of the envelopes written are committed.
+//
+// Every CFMR_BATCHER_FLUSHINTERVAL, an empty write to the output writes the
+// pending batch, the points of the correlator that timed out and the
+// aggregation windows of idle inputs, even if no envelopes are read.
+func (cli *CLI) Process(consumer input.Reader, cache enricher.Enricher, batcher output.AsyncWriter, stats *debug.Stats, stop <-chan struct{}) error {
+	// read in the background, so that stop and the ticks are handled while
+	// waiting for the input. The next envelope is only read once the previous
+	// one is processed.
+	next := make(chan struct{}, 1)
+	reads := make(chan readResult)
+	done := make(chan struct{})
+	defer close(done)
+	defer close(next)
+	go func() {
+		for range next {
+			te, err := consumer.Read()
+			select {
+			case reads <- readResult{te, err}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	reading := false
+
+	var ticks <-chan time.Time
+	if cli.Conf != nil && cli.Conf.Batcher.FlushInterval > 0 {
+		ticker := time.NewTicker(cli.Conf.Batcher.FlushInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
 	for {
-		// Read a message
-		te, err := consumer.Read()
+		if !reading {
+			next <- struct{}{}
+			reading = true
+		}
+		var te *transformer.Envelope
+		var err error
+		select {
+		case <-stop:
+			if err := batcher.Flush(); err != nil {
+				return errors.Wrap(err, "[ERROR] Failed to flush the output")
+			}
+			return nil
+		case <-ticks:
+			if err := batcher.WriteAsync(); err != nil {
+				return errors.Wrap(err, "[ERROR] Failed to write asynchronously")
+			}
+			continue
+		case r := <-reads:
+			te, err, reading = r.te, r.err, false
+		}
 		if err != nil {
+			// the input is closed: write the pending points, including
+			// the open aggregation windows
+			if err := batcher.Flush(); err != nil {
+				return errors.Wrap(err, "[ERROR] Failed to flush the output")
+			}
 			return errors.Wrap(err, "[WARNING] Failed to consume from Kafka")
 		}
 		stats.Inc(debug.Consume, 1)
 
-		// Enrich
-		err = te.Enrich(cache)
-		if err != nil {
+		// Enrich: errors are reported by loggregator components and are always
+		// written, other envelopes without an app only if enabled
+		isError := te.Event.GetEventType() == events.Envelope_Error
+		if isError {
+			stats.Inc(debug.LoggregatorError, 1)
+		}
+		if te.AppGuid() == "" && (isError || cli.Conf != nil && cli.Conf.PlatformEvents) {
+			te.EnrichPlatform()
+		} else if err = te.Enrich(cache); err != nil {
 			errAppNotFound := "CF-AppNotFound"
 			errNoneGUID := "envelope does not contain an app GUID"
 			if !strings.Contains(err.Error(), errAppNotFound) && !strings.Contains(err.Error(), errNoneGUID) {
@@ -209,6 +389,37 @@ func (cli *CLI) Process(consumer input.Reader, cache enricher.Enricher, batcher
 		}
 		stats.Inc(debug.Enrich, 1)
 
+		// Filter
+		if cli.Filter != nil && !cli.Filter.Keep(te) {
+			stats.Inc(debug.Filter, 1)
+			continue
+		}
+
+		// Transform
+		if cli.Transformer != nil {
+			err = cli.Transformer.Transform(te)
+		} else {
+			err = transformer.Transform(te)
+		}
+		if err != nil {
+			if err == transformer.ErrEmptyPoint {
+				stats.Inc(debug.MappingEmpty, 1)
+			} else if err != transformer.ErrEventDiscarded {
+				cli.Logger.Println("[WARN] Failed to transform", te.Meta, err)
+			}
+			continue
+		}
+
+		// Validate
+		if cli.Validator != nil && !cli.Validator.Validate(te) {
+			continue
+		}
+
+		// Limit the cardinality
+		if cli.Cardinality != nil && !cli.Cardinality.Limit(te) {
+			continue
+		}
+
 		// Write a message
 		err = batcher.WriteAsync(te)
 		if err != nil {
@@ -220,6 +431,11 @@ func (cli *CLI) Process(consumer input.Reader, cache enricher.Enricher, batcher
 	}
 }
 
+type readResult struct {
+	te  *transformer.Envelope
+	err error
+}
+
 func (cli *CLI) InputChain() (*input.KafkaConsumer, error) {
 	consumer, err := input.NewKafkaConsumer(&cli.Conf.Kafka)
 	if err != nil {
@@ -228,11 +444,22 @@ func (cli *CLI) InputChain() (*input.KafkaConsumer, error) {
 	return consumer, err
 }
 
-func (cli *CLI) EnricherChain(stats *debug.Stats) (*enricher.CFClient, enricher.Enricher, enricher.Enricher, error) {
-	cfclient, err := enricher.NewCFClient(cli.Conf.CF)
+// Foundation holds the enricher chain built for a single CF foundation
+type Foundation struct {
+	Name          string
+	Enricher      enricher.Enricher // head of the chain
+	CFClient      *enricher.CFClient
+	Cache         *enricher.MemLRUCache
+	NegativeCache *enricher.NegativeMemLRUCache
+}
+
+func (cli *CLI) EnricherChain(cfg enricher.ConfigCF, ownership *enricher.OwnershipMapping, stats *debug.Stats) (*Foundation, error) {
+	cfclient, err := enricher.NewCFClient(cfg, func(err error) {
+		stats.Inc(debug.SettingsFail, 1)
+	})
 	if err != nil {
 		cli.Logger.Println("[ERROR] Failed to create CF API client", err)
-		return &enricher.CFClient{}, nil, nil, err
+		return nil, err
 	}
 	retrier := enricher.NewRetrier(cfclient)
 	cfCallback := enricher.NewCfCallback(retrier, func(err error) {
@@ -242,7 +469,118 @@ func (cli *CLI) EnricherChain(stats *debug.Stats) (*enricher.CFClient, enricher.
 	})
 	negativeCache := enricher.NewNegativeMemLRUCache(cfCallback)
 	cache := enricher.NewMemLRUCache(negativeCache)
-	return cfclient, cache, negativeCache, nil
+	head := cache
+	if cli.Conf.TrustEnvelopeTags {
+		head = enricher.NewEnvelopeTags(cache, cfclient.Name(), cfg.FetchSettings)
+	}
+	if ownership != nil {
+		head = enricher.NewOwnership(head, ownership, cli.Conf.Ownership.Tags)
+	}
+	return &Foundation{
+		Name:          cfclient.Name(),
+		Enricher:      head,
+		CFClient:      cfclient,
+		Cache:         cache.(*enricher.MemLRUCache),
+		NegativeCache: negativeCache.(*enricher.NegativeMemLRUCache),
+	}, nil
+}
+
+// FilterChain builds the Filter selecting the enriched envelopes: the
+// filtering rules, if any, followed by the per-app settings.
+func (cli *CLI) FilterChain() (filter.Filter, error) {
+	chain := filter.Chain{}
+	if cli.Conf.Filter.RulesFile != "" {
+		rules, err := filter.LoadRules(cli.Conf.Filter.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, rules)
+	}
+	return append(chain, filter.NewAppSettings()), nil
+}
+
+// TransformerChain builds the Transformer converting envelopes to points
+func (cli *CLI) TransformerChain(stats *debug.Stats) (*transformer.Transformer, error) {
+	var rules *transformer.LogRules
+	if cli.Conf.Transformer.LogRulesFile != "" {
+		var err error
+		rules, err = transformer.LoadLogRules(cli.Conf.Transformer.LogRulesFile, func(rule string) {
+			stats.IncLogRule(rule)
+		})
+		if err != nil {
+			cli.Logger.Println("[ERROR] Failed to load the log rules", err)
+			return nil, err
+		}
+	}
+	var logMetrics *transformer.LogMetrics
+	if cli.Conf.Transformer.LogMetrics {
+		logMetrics = transformer.NewLogMetrics(cli.Conf.Transformer.LogMetricsMaxSeries, cli.Conf.Transformer.LogMetricsWindow, func(dropped bool) {
+			stats.Inc(debug.LogMetric, 1)
+			if dropped {
+				stats.Inc(debug.LogMetricDropped, 1)
+			}
+		})
+	}
+	var mapping transformer.Mapping
+	if cli.Conf.Transformer.MappingFile != "" {
+		var err error
+		mapping, err = transformer.LoadMapping(cli.Conf.Transformer.MappingFile)
+		if err != nil {
+			cli.Logger.Println("[ERROR] Failed to load the measurement mapping", err)
+			return nil, err
+		}
+		usage := transformer.MappingUsage{
+			Measurements: cli.Conf.Transformer.Collisions.Measurements(),
+			Aggregated:   cli.Conf.Aggregator.Measurements,
+			Fields:       append(append([]string{}, cli.Conf.Aggregator.Fields...), cli.Conf.Aggregator.SketchFields...),
+		}
+		if cli.Conf.Transformer.HTTPDedupe == transformer.HTTPDedupeCorrelate {
+			usage.Tags = []string{transformer.PeerTypeTag}
+		}
+		if err := mapping.Validate(usage); err != nil {
+			cli.Logger.Println("[ERROR] Invalid measurement mapping", err)
+			return nil, err
+		}
+	}
+	var routes *transformer.Routes
+	if cli.Conf.Transformer.Routes {
+		var err error
+		routes, err = transformer.LoadRoutes(cli.Conf.Transformer.RoutesFile, cli.Conf.Transformer.RoutesMaxValues, cli.Conf.Transformer.RoutesWindow, func(capped bool) {
+			if capped {
+				stats.Inc(debug.RouteCapped, 1)
+			}
+		})
+		if err != nil {
+			cli.Logger.Println("[ERROR] Failed to load the route templates", err)
+			return nil, err
+		}
+	}
+	return transformer.NewTransformer(cli.Conf.Transformer, rules, logMetrics, mapping, routes), nil
+}
+
+// ValidatorChain builds the Validator checking the points before they are
+// written. Quarantined points are exposed by the admin endpoints, if enabled.
+func (cli *CLI) ValidatorChain(stats *debug.Stats, admin *debug.Admin) (*transformer.Validator, error) {
+	quarantine := debug.NewQuarantine(cli.Conf.Validator.QuarantineSize)
+	if admin != nil {
+		admin.SetQuarantine(quarantine)
+	}
+	return transformer.NewValidator(cli.Conf.Validator, func(reason string, action transformer.ValidationAction) {
+		stats.IncValidation(reason)
+	}, func(p *transformer.Point, reason string) {
+		fields := make(map[string]string, len(p.Fields))
+		for k, v := range p.Fields {
+			fields[k] = fmt.Sprint(v)
+		}
+		quarantine.Add(debug.QuarantinedPoint{
+			Reason:        reason,
+			Measurement:   p.Name,
+			Tags:          p.Tags,
+			Fields:        fields,
+			Time:          p.Time,
+			QuarantinedAt: time.Now(),
+		})
+	})
 }
 
 func (cli *CLI) OutputChain(consumer *input.KafkaConsumer, stats *debug.Stats) (output.AsyncWriter, error) {
@@ -259,20 +597,55 @@ func (cli *CLI) OutputChain(consumer *input.KafkaConsumer, stats *debug.Stats) (
 		return nil, err
 	}
 
-	outputRetrier := output.NewRetrier(influx)
-	committer := output.NewCommitter(outputRetrier, func(e []*transformer.Envelope) error {
+	return cli.outputChain(output.NewRetrier(influx), consumer.CG.CommitUpto, stats)
+}
+
+// outputChain builds the output chain writing to the sink and committing the
+// offsets of the envelopes written with commit.
+func (cli *CLI) outputChain(sink output.SyncWriter, commit func(*sarama.ConsumerMessage) error, stats *debug.Stats) (output.AsyncWriter, error) {
+	committer := output.NewCommitter(sink, func(e []*transformer.Envelope) error {
 		for _, m := range e {
-			// TODO: commit-after-write is not covered by unit tests and it should be
-			if err := consumer.CG.CommitUpto(m.Input.(*sarama.ConsumerMessage)); err != nil {
+			msg, ok := m.Input.(*sarama.ConsumerMessage)
+			if !ok {
+				// rollups of the aggregator and uncorrelated points
+				continue
+			}
+			if err := commit(msg); err != nil {
 				return err
 			}
 		}
 		stats.Inc(debug.Write, len(e))
 		return nil
 	})
-	batcher := output.NewBatcher(committer, cli.Conf.Batcher)
+	var err error
+	var writer output.AsyncWriter = output.NewBatcher(committer, cli.Conf.Batcher)
+	if len(cli.Conf.Aggregator.Measurements) > 0 {
+		writer, err = output.NewAggregator(writer, cli.Conf.Aggregator, func(late bool) {
+			if late {
+				stats.Inc(debug.AggregateLate, 1)
+			} else {
+				stats.Inc(debug.Aggregate, 1)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return batcher, nil
+	// the correlation comes first, so that the merged points are aggregated
+	if cli.Conf.Transformer.HTTPDedupe == transformer.HTTPDedupeCorrelate {
+		writer, err = output.NewCorrelator(writer, cli.Conf.Correlator, func(merged bool) {
+			if merged {
+				stats.Inc(debug.HTTPCorrelated, 1)
+			} else {
+				stats.Inc(debug.HTTPUncorrelated, 1)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
 }
 
 func (cli *CLI) CGErrorsCheck(consumer *input.KafkaConsumer) {
@@ -303,15 +676,45 @@ func (cli *CLI) NegativeCacheEvict(negativeCache enricher.Enricher) {
 func (cli *CLI) CacheRefresh(cache enricher.Enricher, negativeCache enricher.Enricher, cfclient *enricher.CFClient) {
 	intvl := cli.Conf.MetadataRefresh.Seconds() * (rand.Float64() - 0.5) / 5 // ±10%
 	for _ = range time.Tick(cli.Conf.MetadataRefresh + time.Duration(intvl*float64(time.Second))) {
-		cli.Logger.Println("[INFO] Refreshing metadata cache")
-		start := time.Now()
-		appMetadataRunning, err := cfclient.GetRunningAppMetadata()
-		if err != nil {
+		if err := cli.RefreshCache(cache, negativeCache, cfclient); err != nil {
 			cli.Logger.Println("[WARN] Failed to refresh metadata and negative cache", err)
-		} else {
-			cache.(*enricher.MemLRUCache).Warmup(appMetadataRunning)
-			negativeCache.(*enricher.NegativeMemLRUCache).Warmup(appMetadataRunning)
-			cli.Logger.Printf("[INFO] Refreshing metadata and negative cache: %v", time.Since(start))
+		}
+	}
+}
+
+// RefreshCache fetches a fresh copy of all metadata and merges it into the caches
+func (cli *CLI) RefreshCache(cache enricher.Enricher, negativeCache enricher.Enricher, cfclient *enricher.CFClient) error {
+	cli.Logger.Println("[INFO] Refreshing metadata cache")
+	start := time.Now()
+	appMetadataRunning, err := cfclient.GetRunningAppMetadata()
+	if err != nil {
+		return err
+	}
+	cache.(*enricher.MemLRUCache).Warmup(appMetadataRunning)
+	negativeCache.(*enricher.NegativeMemLRUCache).Warmup(appMetadataRunning)
+	cli.Logger.Printf("[INFO] Refreshing metadata and negative cache: %v", time.Since(start))
+	return nil
+}
+
+// OwnershipReload reloads the ownership mapping when the file changes or when
+// receiving SIGHUP
+func (cli *CLI) OwnershipReload(ownership *enricher.OwnershipMapping) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	tick := time.Tick(cli.Conf.Ownership.ReloadInterval)
+	for {
+		select {
+		case <-c:
+			cli.Logger.Println("[INFO] SIGHUP caught, reloading ownership mapping")
+			if err := ownership.Reload(); err != nil {
+				cli.Logger.Println("[WARN] Failed to reload ownership mapping", err)
+			}
+		case <-tick:
+			if reloaded, err := ownership.ReloadIfChanged(); err != nil {
+				cli.Logger.Println("[WARN] Failed to reload ownership mapping", err)
+			} else if reloaded {
+				cli.Logger.Println("[INFO] Reloaded ownership mapping")
+			}
 		}
 	}
 }
@@ -326,17 +729,13 @@ func (cli *CLI) StatsEmit(stats *debug.Stats) {
 	}
 }
 
-func (cli *CLI) TrapSignals(consumer *input.KafkaConsumer) {
-	c := make(chan os.Signal)
+func (cli *CLI) TrapSignals(stop chan<- struct{}) {
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM)
 	<-c
 	cli.Logger.Println("[INFO] Signal caught")
 
-	cli.Logger.Println("[INFO] Closing input")
-	if err := consumer.Close(); err != nil {
-		cli.Logger.Println("[ERROR] Failed to close input", err)
-	}
-
-	// closing the input will eventually cause consumer.Read() to fail
-	// (in the main processing loop)
+	// the main processing loop flushes the output and returns, then the
+	// input is closed
+	close(stop)
 }
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/pkg/errors"
 	"github.com/rakutentech/cf-metrics-refinery/debug"
@@ -79,6 +80,7 @@ func TestConfigParse(t *testing.T) {
 	NEGATIVECACHEEXPIRECHECK, _ := time.ParseDuration(CFMR_NEGATIVECACHEEXPIRECHECK)
 
 	cfConfig := enricher.ConfigCF{
+		Name:              "default",
 		API:               CFMR_CF_API,
 		User:              CFMR_CF_USER,
 		Password:          CFMR_CF_PASSWORD,
@@ -95,6 +97,7 @@ func TestConfigParse(t *testing.T) {
 		Database:          CFMR_INFLUXDB_DATABASE,
 		RetentionPolicy:   CFMR_INFLUXDB_RETENTIONPOLICY,
 		InfluxPingTimeout: INFLUXDB_INFLUXPINGTIMEOUT,
+		Tags:              transformer.TagPolicy{ReplaceWith: "_"},
 	}
 	batcherConfig := output.ConfigBatcher{
 		FlushInterval: BATCHER_FLUSHINTERVAL,
@@ -108,12 +111,61 @@ func TestConfigParse(t *testing.T) {
 		OffsetNewest:      KAFKA_OFFSETNEWEST,
 	}
 	serverConfig := debug.ConfigServer{
-		Port: CFMR_SERVER_PORT,
+		Port:      CFMR_SERVER_PORT,
+		AdminUser: "admin",
+	}
+	ownershipConfig := enricher.ConfigOwnership{
+		Tags:           []string{"team"},
+		ReloadInterval: time.Minute,
+	}
+	transformerConfig := transformer.ConfigTransformer{
+		LogLevelFields:      []string{"level", "severity"},
+		LogMetricsMaxSeries: 100,
+		LogMetricsWindow:    time.Hour,
+		Collisions: transformer.Collisions{
+			"http_request":   transformer.CollisionNudge,
+			"router_request": transformer.CollisionNudge,
+			"log":            transformer.CollisionNudge,
+		},
+		HTTPDedupe:      transformer.HTTPDedupeNone,
+		RoutesMaxValues: 100,
+		RoutesWindow:    time.Hour,
+		FoundationTag:   true,
+	}
+	validatorConfig := transformer.ConfigValidator{
+		NonFinite:        transformer.ActionDropField,
+		EmptyTag:         transformer.ActionFix,
+		OversizeString:   transformer.ActionFix,
+		MaxStringLength:  65536,
+		InvalidTimestamp: transformer.ActionFix,
+		MaxPast:          8760 * time.Hour,
+		MaxFuture:        time.Hour,
+		QuarantineSize:   100,
 	}
 	wantConfig := Config{
-		CF:                       cfConfig,
-		InfluxDB:                 influxDBConfig,
-		Batcher:                  batcherConfig,
+		CF:          cfConfig,
+		Ownership:   ownershipConfig,
+		Transformer: transformerConfig,
+		Validator:   validatorConfig,
+		Cardinality: transformer.ConfigCardinality{
+			Window:   time.Hour,
+			Action:   transformer.CardinalityCollapse,
+			KeepTags: []string{"org", "org_guid", "space", "space_guid", "foundation"},
+		},
+		InfluxDB: influxDBConfig,
+		Batcher:  batcherConfig,
+		Aggregator: output.ConfigAggregator{
+			Window:         10 * time.Second,
+			Watermark:      30 * time.Second,
+			Fields:         []string{"duration", "response_time", "size", "response_size"},
+			SketchFields:   []string{"duration", "response_time"},
+			Percentiles:    []float64{50, 95, 99},
+			SketchAccuracy: 0.01,
+		},
+		Correlator: output.ConfigCorrelator{
+			Timeout:    5 * time.Second,
+			MaxPending: 100000,
+		},
 		Kafka:                    kafkaConfig,
 		Server:                   serverConfig,
 		MetadataRefresh:          METADATAREFRESH,
@@ -220,8 +272,18 @@ func mockEvent(logMsg string) *events.Envelope {
 	return &e
 }
 
+// mockTransformed returns the envelope as enriched and transformed by Process
+func mockTransformed(e *events.Envelope, meta enricher.AppMetadata) *transformer.Envelope {
+	env := &transformer.Envelope{Event: e, Meta: meta}
+	if err := transformer.Transform(env); err != nil {
+		panic(err)
+	}
+	return env
+}
+
 type mockReader struct {
 	Envelope *transformer.Envelope
+	Msg      string // defaults to LogMsg
 	Err      error
 	l        sync.Mutex
 }
@@ -234,7 +296,11 @@ func (mr *mockReader) Read() (*transformer.Envelope, error) {
 		return nil, mr.Err
 	}
 
-	envelope := &transformer.Envelope{Event: mockEvent(LogMsg)}
+	msg := mr.Msg
+	if msg == "" {
+		msg = LogMsg
+	}
+	envelope := &transformer.Envelope{Event: mockEvent(msg)}
 	mr.Envelope = envelope
 	return envelope, nil
 }
@@ -258,9 +324,10 @@ func (me *mockEnricher) GetAppMetadata(appGUID string) (enricher.AppMetadata, er
 }
 
 type mockWriteAsync struct {
-	Envs []*transformer.Envelope
-	Err  error
-	l    sync.Mutex
+	Envs    []*transformer.Envelope
+	Err     error
+	Flushed int
+	l       sync.Mutex
 }
 
 func (mwa *mockWriteAsync) WriteAsync(envs ...*transformer.Envelope) error {
@@ -277,6 +344,10 @@ func (mwa *mockWriteAsync) WriteAsync(envs ...*transformer.Envelope) error {
 }
 
 func (mwa *mockWriteAsync) Flush() error {
+	mwa.l.Lock()
+	defer mwa.l.Unlock()
+
+	mwa.Flushed++
 	return mwa.Err
 }
 
@@ -289,7 +360,7 @@ func TestProcess_ReadFail(t *testing.T) {
 
 	mr.Err = errors.New("mock error for reading message")
 
-	err := cli.Process(mr, me, mwa, s)
+	err := cli.Process(mr, me, mwa, s, nil)
 	if err == nil {
 		t.Fatal("TestProcess_ReadFail: expected error, got nil")
 	}
@@ -303,6 +374,10 @@ func TestProcess_ReadFail(t *testing.T) {
 	if s.Consume != wantConsume {
 		t.Fatalf("TestProcess_ReadFail: expected Consume %v, got %v", wantConsume, s.Consume)
 	}
+
+	if mwa.Flushed != 1 {
+		t.Fatalf("TestProcess_ReadFail: expected the output to be flushed once, got %d", mwa.Flushed)
+	}
 }
 
 func TestProcess_WriteAsyncFail(t *testing.T) {
@@ -313,12 +388,12 @@ func TestProcess_WriteAsyncFail(t *testing.T) {
 	s := &debug.Stats{}
 
 	mwa.Err = errors.New("mock error for WriteAsync")
-	err := cli.Process(mr, me, mwa, s)
+	err := cli.Process(mr, me, mwa, s, nil)
 	if err == nil {
 		t.Fatal("TestProcess_WriteAsyncFail: expected error, got nil")
 	}
 
-	wantEnvelope := &transformer.Envelope{Event: mockEvent(LogMsg), Meta: appMeta}
+	wantEnvelope := mockTransformed(mockEvent(LogMsg), appMeta)
 	if !reflect.DeepEqual(wantEnvelope, mr.Envelope) {
 		t.Fatalf("TestProcess_WriteAsyncFail: expected Envelope %v, got %v", wantEnvelope, mr.Envelope)
 	}
@@ -342,7 +417,15 @@ func TestProcess_EnrichFail(t *testing.T) {
 	s := &debug.Stats{}
 
 	go func() {
-		me.l.Lock()
+		// wait for the first lookup, as the input is read in the background
+		for {
+			me.l.Lock()
+			if me.AppMeta.App != "" {
+				break
+			}
+			me.l.Unlock()
+			time.Sleep(time.Millisecond)
+		}
 		defer me.l.Unlock()
 
 		mr.l.Lock()
@@ -356,7 +439,7 @@ func TestProcess_EnrichFail(t *testing.T) {
 		me.Err = errors.New("mock error for enriching")
 		mr.Err = errors.New("mock error for reading message")
 	}()
-	err := cli.Process(mr, me, mwa, s)
+	err := cli.Process(mr, me, mwa, s, nil)
 	if err == nil {
 		t.Fatal("TestProcess_EnrichFail: expected error, got nil")
 	}
@@ -370,11 +453,19 @@ func TestProcess_Success(t *testing.T) {
 	s := &debug.Stats{}
 
 	go func() {
-		mwa.l.Lock()
+		// wait for the first write, as the input is read in the background
+		for {
+			mwa.l.Lock()
+			if mwa.Envs != nil {
+				break
+			}
+			mwa.l.Unlock()
+			time.Sleep(time.Millisecond)
+		}
 		defer mwa.l.Unlock()
 
 		var wantEnvs []*transformer.Envelope
-		wantEnvs = append(wantEnvs, &transformer.Envelope{Event: mockEvent(LogMsg), Meta: appMeta})
+		wantEnvs = append(wantEnvs, mockTransformed(mockEvent(LogMsg), appMeta))
 		if !reflect.DeepEqual(wantEnvs, mwa.Envs) {
 			t.Fatalf("TestProcess_Success: expected []*transformer.Envelope %v, got %v", wantEnvs, mwa.Envs)
 		}
@@ -382,12 +473,12 @@ func TestProcess_Success(t *testing.T) {
 		mwa.Err = errors.New("mock error for WriteAsync")
 	}()
 
-	err := cli.Process(mr, me, mwa, s)
+	err := cli.Process(mr, me, mwa, s, nil)
 	if err == nil {
 		t.Fatal("TestProcess_Success: expected error, got nil")
 	}
 
-	wantEnvelope := &transformer.Envelope{Event: mockEvent(LogMsg), Meta: appMeta}
+	wantEnvelope := mockTransformed(mockEvent(LogMsg), appMeta)
 	if !reflect.DeepEqual(wantEnvelope, mr.Envelope) {
 		t.Fatalf("TestProcess_Success: expected Envelope %v, got %v", wantEnvelope, mr.Envelope)
 	}
@@ -402,3 +493,117 @@ func TestProcess_Success(t *testing.T) {
 		t.Fatalf("TestProcess_WriteAsyncFail: expected empty []*transformer.Envelope %v, got %v", wantEmptyEnvs, mwa.Envs)
 	}
 }
+
+// chanReader reads the envelopes of a channel. Once it is empty, it closes
+// drained and blocks until closed is closed, then fails like a closed input.
+type chanReader struct {
+	envs    chan *transformer.Envelope
+	drained chan struct{}
+	closed  chan struct{}
+}
+
+func (r *chanReader) Read() (*transformer.Envelope, error) {
+	select {
+	case e := <-r.envs:
+		return e, nil
+	default:
+	}
+	close(r.drained)
+	<-r.closed
+	return nil, errors.New("input closed")
+}
+
+type recordSyncWriter struct {
+	points []*transformer.Point
+	l      sync.Mutex
+}
+
+func (w *recordSyncWriter) Write(envs ...*transformer.Envelope) error {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	for _, e := range envs {
+		w.points = append(w.points, e.Points()...)
+	}
+	return nil
+}
+
+func TestProcess_Shutdown(t *testing.T) {
+	cli := &CLI{Conf: &Config{
+		Batcher:    output.ConfigBatcher{FlushInterval: time.Hour, FlushMessages: 1000},
+		Aggregator: output.ConfigAggregator{Measurements: []string{"log"}, Window: time.Minute, Watermark: time.Minute},
+	}}
+	s := &debug.Stats{}
+	sink := &recordSyncWriter{}
+	var committed []int64
+	writer, err := cli.outputChain(sink, func(msg *sarama.ConsumerMessage) error {
+		committed = append(committed, msg.Offset)
+		return nil
+	}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &chanReader{envs: make(chan *transformer.Envelope, 3), drained: make(chan struct{}), closed: make(chan struct{})}
+	defer close(r.closed)
+	for i := 0; i < 3; i++ {
+		r.envs <- &transformer.Envelope{Event: mockEvent(LogMsg), Input: &sarama.ConsumerMessage{Offset: int64(i)}}
+	}
+	stop := make(chan struct{})
+	errc := make(chan error)
+	go func() {
+		errc <- cli.Process(r, &mockEnricher{}, writer, s, stop)
+	}()
+
+	<-r.drained
+	if len(committed) != 0 {
+		t.Fatalf("TestProcess_Shutdown: expected no commits before stopping, got %v", committed)
+	}
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatalf("TestProcess_Shutdown: expected no error, got %v", err)
+	}
+
+	// the input is still open: the offsets are committed before closing it
+	if want := []int64{0, 1, 2}; !reflect.DeepEqual(committed, want) {
+		t.Fatalf("TestProcess_Shutdown: expected the offsets %v to be committed, got %v", want, committed)
+	}
+	if len(sink.points) != 1 || sink.points[0].Fields["count"] != int64(3) {
+		t.Fatalf("TestProcess_Shutdown: expected the rollup of the open window, got %v", sink.points)
+	}
+}
+
+const ErrorMsg = `{
+	"origin": "loggregator",
+	"eventType": 8,
+	"timestamp": 123456789012345678,
+	"job": "doppler",
+	"index": "0",
+	"error": {
+		"source": "doppler",
+		"code": 3,
+		"message": "dropped messages"
+	}
+}`
+
+func TestProcess_Error(t *testing.T) {
+	cli := &CLI{}
+	mr := &mockReader{Msg: ErrorMsg}
+	me := &mockEnricher{}
+	mwa := &mockWriteAsync{Err: errors.New("mock error for WriteAsync")}
+	s := &debug.Stats{}
+
+	if err := cli.Process(mr, me, mwa, s, nil); err == nil {
+		t.Fatal("TestProcess_Error: expected error, got nil")
+	}
+
+	if mr.Envelope.Platform == nil || mr.Envelope.Platform.Job != "doppler" {
+		t.Fatalf("TestProcess_Error: expected platform metadata, got %v", mr.Envelope.Platform)
+	}
+	if me.AppMeta.App != "" {
+		t.Fatalf("TestProcess_Error: expected no app lookup, got %v", me.AppMeta)
+	}
+	if s.LoggregatorError != 1 || s.Enrich != 1 {
+		t.Fatalf("TestProcess_Error: expected 1 loggregator error and 1 enrich, got %d and %d", s.LoggregatorError, s.Enrich)
+	}
+}
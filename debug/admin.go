@@ -0,0 +1,251 @@
+package debug
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+// MetadataCache is implemented by the metadata caches that can be inspected
+// and manipulated by the admin endpoints.
+type MetadataCache interface {
+	Lookup(appGUID string) (enricher.CacheEntry, bool)
+	Entries() []enricher.CacheEntry
+	Invalidate(appGUID string) bool
+	InvalidateAll() int
+}
+
+// AdminFoundation groups the caches of a CF foundation exposed by the admin
+// endpoints.
+type AdminFoundation struct {
+	Cache         MetadataCache
+	NegativeCache MetadataCache
+	// Refresh fetches a fresh copy of all metadata into the caches
+	Refresh func() error
+}
+
+// Admin serves the authenticated endpoints used to inspect and manipulate
+// the metadata caches:
+//
+//	GET  /admin/cache?guid=GUID        look up an app in all caches
+//	GET  /admin/cache/dump             dump the content of all caches
+//	POST /admin/cache/invalidate?guid=GUID
+//	POST /admin/cache/invalidate       invalidate all entries
+//	POST /admin/cache/refresh          refresh the caches immediately
+//	GET  /admin/quarantine             dump the quarantined points
+//
+// The cache endpoints accept an optional foundation=NAME parameter to restrict
+// the operation to a single foundation.
+type Admin struct {
+	user, password string
+
+	l           sync.Mutex
+	foundations map[string]AdminFoundation
+	quarantine  *Quarantine
+}
+
+// NewAdmin creates an Admin handler protected by basic authentication.
+func NewAdmin(user, password string) *Admin {
+	return &Admin{
+		user:        user,
+		password:    password,
+		foundations: make(map[string]AdminFoundation),
+	}
+}
+
+// AddFoundation exposes the caches of a foundation.
+func (a *Admin) AddFoundation(name string, f AdminFoundation) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.foundations[name] = f
+}
+
+// SetQuarantine exposes the points quarantined by the validation stage.
+func (a *Admin) SetQuarantine(q *Quarantine) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	a.quarantine = q
+}
+
+type quarantineDump struct {
+	Total  uint64             `json:"total"`
+	Points []QuarantinedPoint `json:"points"`
+}
+
+type cacheLookup struct {
+	Cache         *enricher.CacheEntry `json:"cache"`
+	NegativeCache *enricher.CacheEntry `json:"negative_cache"`
+}
+
+type cacheDump struct {
+	Cache         []enricher.CacheEntry `json:"cache"`
+	NegativeCache []enricher.CacheEntry `json:"negative_cache"`
+}
+
+type cacheInvalidate struct {
+	Cache         int `json:"cache"`
+	NegativeCache int `json:"negative_cache"`
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || !a.authorized(user, password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="cf-metrics-refinery"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/admin/quarantine" {
+		a.serveQuarantine(w, r)
+		return
+	}
+
+	foundations, err := a.selectFoundations(r.URL.Query().Get("foundation"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	guid := r.URL.Query().Get("guid")
+
+	res := make(map[string]interface{}, len(foundations))
+	switch r.URL.Path {
+	case "/admin/cache":
+		if r.Method != http.MethodGet {
+			http.Error(w, "GET required", http.StatusMethodNotAllowed)
+			return
+		}
+		if guid == "" {
+			http.Error(w, "guid parameter required", http.StatusBadRequest)
+			return
+		}
+		for name, f := range foundations {
+			l := cacheLookup{}
+			if e, ok := f.Cache.Lookup(guid); ok {
+				l.Cache = &e
+			}
+			if e, ok := f.NegativeCache.Lookup(guid); ok {
+				l.NegativeCache = &e
+			}
+			res[name] = l
+		}
+
+	case "/admin/cache/dump":
+		if r.Method != http.MethodGet {
+			http.Error(w, "GET required", http.StatusMethodNotAllowed)
+			return
+		}
+		for name, f := range foundations {
+			res[name] = cacheDump{
+				Cache:         sortedEntries(f.Cache.Entries()),
+				NegativeCache: sortedEntries(f.NegativeCache.Entries()),
+			}
+		}
+
+	case "/admin/cache/invalidate":
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		for name, f := range foundations {
+			i := cacheInvalidate{}
+			if guid == "" {
+				i.Cache = f.Cache.InvalidateAll()
+				i.NegativeCache = f.NegativeCache.InvalidateAll()
+			} else {
+				if f.Cache.Invalidate(guid) {
+					i.Cache = 1
+				}
+				if f.NegativeCache.Invalidate(guid) {
+					i.NegativeCache = 1
+				}
+			}
+			res[name] = i
+		}
+
+	case "/admin/cache/refresh":
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		status := http.StatusOK
+		for name, f := range foundations {
+			if err := f.Refresh(); err != nil {
+				res[name] = err.Error()
+				status = http.StatusBadGateway
+			} else {
+				res[name] = "ok"
+			}
+		}
+		writeJSON(w, status, res)
+		return
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (a *Admin) serveQuarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	a.l.Lock()
+	q := a.quarantine
+	a.l.Unlock()
+
+	dump := quarantineDump{Points: []QuarantinedPoint{}}
+	if q != nil {
+		dump.Points, dump.Total = q.Points()
+	}
+	writeJSON(w, http.StatusOK, dump)
+}
+
+func (a *Admin) authorized(user, password string) bool {
+	// evaluate both comparisons to avoid leaking which one failed
+	u := subtle.ConstantTimeCompare([]byte(user), []byte(a.user))
+	p := subtle.ConstantTimeCompare([]byte(password), []byte(a.password))
+	return u&p == 1
+}
+
+func (a *Admin) selectFoundations(name string) (map[string]AdminFoundation, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if name == "" {
+		all := make(map[string]AdminFoundation, len(a.foundations))
+		for n, f := range a.foundations {
+			all[n] = f
+		}
+		return all, nil
+	}
+	f, found := a.foundations[name]
+	if !found {
+		return nil, fmt.Errorf("unknown foundation %q", name)
+	}
+	return map[string]AdminFoundation{name: f}, nil
+}
+
+func sortedEntries(entries []enricher.CacheEntry) []enricher.CacheEntry {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AppGUID < entries[j].AppGUID })
+	return entries
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Internal Server Error: %s\n", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
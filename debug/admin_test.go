@@ -0,0 +1,153 @@
+package debug
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+type mockCache struct {
+	entries map[string]enricher.CacheEntry
+}
+
+func newMockCache(guids ...string) *mockCache {
+	c := &mockCache{entries: make(map[string]enricher.CacheEntry)}
+	for _, g := range guids {
+		c.entries[g] = enricher.CacheEntry{AppGUID: g, LastSeen: time.Now()}
+	}
+	return c
+}
+
+func (c *mockCache) Lookup(appGUID string) (enricher.CacheEntry, bool) {
+	e, ok := c.entries[appGUID]
+	return e, ok
+}
+
+func (c *mockCache) Entries() []enricher.CacheEntry {
+	entries := make([]enricher.CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (c *mockCache) Invalidate(appGUID string) bool {
+	_, ok := c.entries[appGUID]
+	delete(c.entries, appGUID)
+	return ok
+}
+
+func (c *mockCache) InvalidateAll() int {
+	n := len(c.entries)
+	c.entries = make(map[string]enricher.CacheEntry)
+	return n
+}
+
+func TestAdmin(t *testing.T) {
+	refreshed := 0
+	a := NewAdmin("admin", "secret")
+	a.AddFoundation("east", AdminFoundation{
+		Cache:         newMockCache("guid1", "guid2"),
+		NegativeCache: newMockCache("guid3"),
+		Refresh:       func() error { refreshed++; return nil },
+	})
+	a.AddFoundation("west", AdminFoundation{
+		Cache:         newMockCache("guid4"),
+		NegativeCache: newMockCache(),
+		Refresh:       func() error { return errors.New("CC is down") },
+	})
+	q := NewQuarantine(1)
+	q.Add(QuarantinedPoint{Reason: "old"})
+	q.Add(QuarantinedPoint{Reason: "non_finite", Measurement: "instance", Fields: map[string]string{"memory_pct": "NaN"}})
+	a.SetQuarantine(q)
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		password   string
+		wantStatus int
+		wantBody   string
+	}{
+		{"unauthenticated", "GET", "/admin/cache/dump", "", http.StatusUnauthorized, ""},
+		{"wrong password", "GET", "/admin/cache/dump", "wrong", http.StatusUnauthorized, ""},
+		{"unknown endpoint", "GET", "/admin/unknown", "secret", http.StatusNotFound, ""},
+		{"unknown foundation", "GET", "/admin/cache/dump?foundation=north", "secret", http.StatusNotFound, ""},
+		{"lookup without guid", "GET", "/admin/cache", "secret", http.StatusBadRequest, ""},
+		{"lookup with POST", "POST", "/admin/cache?guid=guid3", "secret", http.StatusMethodNotAllowed, ""},
+		{"lookup", "GET", "/admin/cache?guid=guid3", "secret", http.StatusOK, `{"east":{"cache":null,"negative_cache":{"app_guid":"guid3"}},"west":{"cache":null,"negative_cache":null}}`},
+		{"dump", "GET", "/admin/cache/dump?foundation=west", "secret", http.StatusOK, `{"west":{"cache":[{"app_guid":"guid4"}],"negative_cache":[]}}`},
+		{"invalidate with GET", "GET", "/admin/cache/invalidate", "secret", http.StatusMethodNotAllowed, ""},
+		{"invalidate guid", "POST", "/admin/cache/invalidate?guid=guid1", "secret", http.StatusOK, `{"east":{"cache":1,"negative_cache":0},"west":{"cache":0,"negative_cache":0}}`},
+		{"invalidate all", "POST", "/admin/cache/invalidate?foundation=east", "secret", http.StatusOK, `{"east":{"cache":1,"negative_cache":1}}`},
+		{"refresh", "POST", "/admin/cache/refresh?foundation=east", "secret", http.StatusOK, `{"east":"ok"}`},
+		{"quarantine", "GET", "/admin/quarantine", "secret", http.StatusOK, `{"total":2,"points":[{"reason":"non_finite","measurement":"instance","tags":null,"fields":{"memory_pct":"NaN"},"time":"0001-01-01T00:00:00Z","quarantined_at":"0001-01-01T00:00:00Z"}]}`},
+		{"refresh failure", "POST", "/admin/cache/refresh", "secret", http.StatusBadGateway, `{"east":"ok","west":"CC is down"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.password != "" {
+				r.SetBasicAuth("admin", tt.password)
+			}
+			resp, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			// compare ignoring the last seen timestamps
+			var got, want interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.wantBody), &want); err != nil {
+				t.Fatal(err)
+			}
+			if g, w := stripLastSeen(got), stripLastSeen(want); !jsonEqual(g, w) {
+				t.Fatalf("expected %s, got %v", tt.wantBody, g)
+			}
+		})
+	}
+
+	if refreshed != 2 {
+		t.Fatalf("expected 2 refreshes, got %d", refreshed)
+	}
+}
+
+func stripLastSeen(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "last_seen")
+		for k := range v {
+			v[k] = stripLastSeen(v[k])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = stripLastSeen(v[i])
+		}
+	}
+	return v
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
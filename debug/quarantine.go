@@ -0,0 +1,59 @@
+package debug
+
+import (
+	"sync"
+	"time"
+)
+
+// QuarantinedPoint is a point rejected by the validation stage and kept for
+// inspection.
+type QuarantinedPoint struct {
+	Reason      string            `json:"reason"`
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+	// Fields are formatted as strings, as JSON can not encode NaN and Inf
+	Fields        map[string]string `json:"fields"`
+	Time          time.Time         `json:"time"`
+	QuarantinedAt time.Time         `json:"quarantined_at"`
+}
+
+// Quarantine keeps the last quarantined points.
+type Quarantine struct {
+	size int
+
+	l      sync.Mutex
+	points []QuarantinedPoint
+	total  uint64
+}
+
+// NewQuarantine creates a Quarantine keeping at most size points.
+func NewQuarantine(size int) *Quarantine {
+	return &Quarantine{size: size}
+}
+
+// Add adds a point, evicting the oldest one if the quarantine is full.
+func (q *Quarantine) Add(p QuarantinedPoint) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	q.total++
+	if q.size <= 0 {
+		return
+	}
+	if len(q.points) == q.size {
+		copy(q.points, q.points[1:])
+		q.points = q.points[:len(q.points)-1]
+	}
+	q.points = append(q.points, p)
+}
+
+// Points returns the quarantined points, oldest first, and the total number
+// of points quarantined so far.
+func (q *Quarantine) Points() ([]QuarantinedPoint, uint64) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	points := make([]QuarantinedPoint, len(q.points))
+	copy(points, q.points)
+	return points, q.total
+}
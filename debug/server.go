@@ -2,16 +2,20 @@ package debug
 
 import (
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"strconv"
+	"sync"
 
 	"github.com/fukata/golang-stats-api-handler"
 )
 
 type ConfigServer struct {
-	Port string `default:"8080" desc:"port of http server"` // CFMR_SERVER_PORT
+	Port          string `default:"8080" desc:"port of http server"`                             // CFMR_SERVER_PORT
+	AdminUser     string `default:"admin" desc:"Username for the admin endpoints"`               // CFMR_SERVER_ADMINUSER
+	AdminPassword string `desc:"Password for the admin endpoints (if empty, they are disabled)"` // CFMR_SERVER_ADMINPASSWORD
 }
 
 type statsHandler struct {
@@ -24,21 +28,33 @@ type Server struct {
 	Port   string
 	Logger *log.Logger
 	Stats  *Stats
+	// Admin serves the admin endpoints; it is nil if they are disabled.
+	Admin *Admin
+
+	l       sync.Mutex
+	reports []string
 }
 
 // Start starts listening.
 func NewServer(cs *ConfigServer, stats *Stats, logger *log.Logger) (*Server, error) {
-	return &Server{Port: cs.Port, Stats: stats, Logger: logger}, nil
+	s := &Server{Port: cs.Port, Stats: stats, Logger: logger}
+	if cs.AdminPassword != "" {
+		s.Admin = NewAdmin(cs.AdminUser, cs.AdminPassword)
+	}
+	return s, nil
 }
 
 func (s *Server) Start() *http.Server {
 	srv := &http.Server{Addr: ":" + s.Port}
 
-	http.HandleFunc("/", index)
+	http.HandleFunc("/", s.index)
 	http.Handle("/stats/app", &statsHandler{
 		stats: s.Stats,
 	})
 	http.HandleFunc("/stats/runtime", stats_api.Handler)
+	if s.Admin != nil {
+		http.Handle("/admin/", s.Admin)
+	}
 
 	go func() {
 		s.Logger.Printf("[INFO] Start server listening on :%s", s.Port)
@@ -51,18 +67,38 @@ func (s *Server) Start() *http.Server {
 	return srv
 }
 
-func index(w http.ResponseWriter, _ *http.Request) {
-	body := `
+// AddReport exposes the JSON encoding of the value returned by report on
+// path (e.g. /stats/cardinality). It can be called after Start.
+func (s *Server) AddReport(path string, report func() interface{}) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.reports = append(s.reports, path)
+	http.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, report())
+	})
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`
 		<a href="https://github.com/rakutentech/cf-metrics-refinery">cf-metrics-refinery</a> 
 		<ul>
 		  <li><a href="/stats/runtime">stats/runtime</a></li>
 		  <li><a href="/debug/pprof/">pprof</a></li>
 		  <li><a href="/stats/app">stats/app</a></li>
+		  {{- range .}}
+		  <li><a href="{{.}}">{{.}}</a></li>
+		  {{- end}}
 		</ul>
-		      `
+		      `))
+
+func (s *Server) index(w http.ResponseWriter, _ *http.Request) {
+	s.l.Lock()
+	reports := append([]string(nil), s.reports...)
+	s.l.Unlock()
+
 	w.Header().Set("Content-type", "text/html")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(body))
+	indexTemplate.Execute(w, reports)
 }
 
 func (h *statsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -4,7 +4,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -13,6 +15,7 @@ func TestIndex(t *testing.T) {
 	s, _ := NewServer(&ConfigServer{Port: "8080"}, &Stats{}, log.New(os.Stdout, "TEST", log.LstdFlags))
 	srv := s.Start()
 	defer func() { srv.Shutdown(nil) }()
+	s.AddReport("/stats/test", func() interface{} { return map[string]int{"series": 1} })
 	URL := "http://127.0.0.1:8080"
 
 	//FIXME: need to Wait for the http server to start fully.
@@ -38,14 +41,32 @@ func TestIndex(t *testing.T) {
 		{name: "post /debug/pprof/", r: newreq("POST", URL+"/debug/pprof/", nil)},
 		{name: "get /stats/app", r: newreq("GET", URL+"/stats/app", nil)},
 		{name: "post /stats/app", r: newreq("POST", URL+"/stats/app", nil)},
+		{name: "get /stats/test", r: newreq("GET", URL+"/stats/test", nil)},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			resp, err := http.DefaultClient.Do(tt.r)
-			defer resp.Body.Close()
 			if err != nil {
 				t.Fatal(err)
 			}
+			defer resp.Body.Close()
 		})
 	}
 }
+
+func TestAddReport(t *testing.T) {
+	s, _ := NewServer(&ConfigServer{Port: "8081"}, &Stats{}, log.New(os.Stdout, "TEST", log.LstdFlags))
+	s.AddReport("/stats/report", func() interface{} { return map[string]int{"series": 1} })
+
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest("GET", "/stats/report", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"series":1}` {
+		t.Fatalf("expected the report, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.index(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), `<a href="/stats/report">`) {
+		t.Fatalf("expected a link to the report in the index, got %q", w.Body.String())
+	}
+}
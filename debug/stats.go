@@ -17,35 +17,87 @@ const (
 type StatsType int
 
 const (
-	Consume    StatsType = iota // messages received
-	Enrich                      // messages enriched
-	EnrichFail                  // messages failed to be enriched
-	WriteAsync                  // points added to Influxdb batch
-	Write                       // points written to Influxdb
-	CFFail                      // CF API lookup failure
+	Consume          StatsType = iota // messages received
+	Enrich                            // messages enriched
+	EnrichFail                        // messages failed to be enriched
+	WriteAsync                        // points added to Influxdb batch
+	Write                             // points written to Influxdb
+	CFFail                            // CF API lookup failure
+	Filter                            // messages dropped by filters
+	LoggregatorError                  // Error envelopes received
+	LogMetric                         // metrics embedded in app logs
+	LogMetricDropped                  // metrics embedded in app logs dropped by the cardinality limit
+	Aggregate                         // points aggregated into rollups
+	AggregateLate                     // points written as is because their window was closed
+	CardinalityLimit                  // points of new series over the cardinality limit
+	HTTPCorrelated                    // HttpStartStop pairs merged into one point
+	HTTPUncorrelated                  // HttpStartStop events written alone after the correlation timeout
+	RouteCapped                       // route tags replaced by __other__ over the per-app limit
+	MappingEmpty                      // points discarded because the mapping left them without fields
+	SettingsFail                      // app settings failed to be fetched from the CF API
 )
 
 // Stats stores various stats infomation
 type Stats struct {
-	l                  sync.Mutex
-	Consume            uint64    `json:"consume"`
-	ConsumePerSec      uint64    `json:"consume_per_sec"`
-	Enrich             uint64    `json:"enrich"`
-	EnrichPerSec       uint64    `json:"enrich_per_sec"`
-	EnrichFail         uint64    `json:"enrichfail"`
-	EnrichFailPerSec   uint64    `json:"enrichfail_per_sec"`
-	WriteAsync         uint64    `json:"writeasync"`
-	WriteAsyncPerSec   uint64    `json:"writeasync_per_sec"`
-	Write              uint64    `json:"write"`
-	WritePerSec        uint64    `json:"write_per_sec"`
-	CFFail             uint64    `json:"cffail"`
-	CFFailPerSec       uint64    `json:"cffail_per_sec"`
-	LastConsumeTime    time.Time `json:"last_consume_time"`
-	LastEnrichTime     time.Time `json:"last_enrich_time"`
-	LastEnrichFailTime time.Time `json:"last_enrich_fail_time"`
-	LastWriteAsyncTime time.Time `json:"last_writeasync_time"`
-	LastWriteTime      time.Time `json:"last_write_time"`
-	LastCFFailTime     time.Time `json:"last_cffail_time"`
+	l                        sync.Mutex
+	Consume                  uint64    `json:"consume"`
+	ConsumePerSec            uint64    `json:"consume_per_sec"`
+	Enrich                   uint64    `json:"enrich"`
+	EnrichPerSec             uint64    `json:"enrich_per_sec"`
+	EnrichFail               uint64    `json:"enrichfail"`
+	EnrichFailPerSec         uint64    `json:"enrichfail_per_sec"`
+	WriteAsync               uint64    `json:"writeasync"`
+	WriteAsyncPerSec         uint64    `json:"writeasync_per_sec"`
+	Write                    uint64    `json:"write"`
+	WritePerSec              uint64    `json:"write_per_sec"`
+	CFFail                   uint64    `json:"cffail"`
+	CFFailPerSec             uint64    `json:"cffail_per_sec"`
+	Filter                   uint64    `json:"filter"`
+	FilterPerSec             uint64    `json:"filter_per_sec"`
+	LoggregatorError         uint64    `json:"loggregator_error"`
+	LoggregatorErrorPerSec   uint64    `json:"loggregator_error_per_sec"`
+	LogMetric                uint64    `json:"log_metric"`
+	LogMetricPerSec          uint64    `json:"log_metric_per_sec"`
+	LogMetricDropped         uint64    `json:"log_metric_dropped"`
+	LogMetricDroppedPerSec   uint64    `json:"log_metric_dropped_per_sec"`
+	Aggregate                uint64    `json:"aggregate"`
+	AggregatePerSec          uint64    `json:"aggregate_per_sec"`
+	AggregateLate            uint64    `json:"aggregate_late"`
+	AggregateLatePerSec      uint64    `json:"aggregate_late_per_sec"`
+	CardinalityLimit         uint64    `json:"cardinality_limit"`
+	CardinalityLimitPerSec   uint64    `json:"cardinality_limit_per_sec"`
+	HTTPCorrelated           uint64    `json:"http_correlated"`
+	HTTPCorrelatedPerSec     uint64    `json:"http_correlated_per_sec"`
+	HTTPUncorrelated         uint64    `json:"http_uncorrelated"`
+	HTTPUncorrelatedPerSec   uint64    `json:"http_uncorrelated_per_sec"`
+	RouteCapped              uint64    `json:"route_capped"`
+	RouteCappedPerSec        uint64    `json:"route_capped_per_sec"`
+	MappingEmpty             uint64    `json:"mapping_empty"`
+	MappingEmptyPerSec       uint64    `json:"mapping_empty_per_sec"`
+	SettingsFail             uint64    `json:"settings_fail"`
+	SettingsFailPerSec       uint64    `json:"settings_fail_per_sec"`
+	LastConsumeTime          time.Time `json:"last_consume_time"`
+	LastEnrichTime           time.Time `json:"last_enrich_time"`
+	LastEnrichFailTime       time.Time `json:"last_enrich_fail_time"`
+	LastWriteAsyncTime       time.Time `json:"last_writeasync_time"`
+	LastWriteTime            time.Time `json:"last_write_time"`
+	LastCFFailTime           time.Time `json:"last_cffail_time"`
+	LastFilterTime           time.Time `json:"last_filter_time"`
+	LastLoggregatorErrorTime time.Time `json:"last_loggregator_error_time"`
+	LastLogMetricTime        time.Time `json:"last_log_metric_time"`
+	LastLogMetricDroppedTime time.Time `json:"last_log_metric_dropped_time"`
+	LastAggregateTime        time.Time `json:"last_aggregate_time"`
+	LastAggregateLateTime    time.Time `json:"last_aggregate_late_time"`
+	LastCardinalityLimitTime time.Time `json:"last_cardinality_limit_time"`
+	LastHTTPCorrelatedTime   time.Time `json:"last_http_correlated_time"`
+	LastHTTPUncorrelatedTime time.Time `json:"last_http_uncorrelated_time"`
+	LastRouteCappedTime      time.Time `json:"last_route_capped_time"`
+	LastMappingEmptyTime     time.Time `json:"last_mapping_empty_time"`
+	LastSettingsFailTime     time.Time `json:"last_settings_fail_time"`
+	// LogRules counts the matches of each log rule
+	LogRules map[string]uint64 `json:"log_rules,omitempty"`
+	// Validation counts the problems found by the validation stage
+	Validation map[string]uint64 `json:"validation,omitempty"`
 	// InstanceIndex is the index for cf-metrics-refinery instance.
 	// This is used to identify stats from different instances.
 	// By default, it's defaultInstanceIndex
@@ -61,7 +113,7 @@ func NewStats() *Stats {
 }
 
 func (s *Stats) PerSec() {
-	var lastConsume, lastEnrich, lastEnrichFail, lastWriteAsync, lastWrite, lastCFFail uint64
+	var lastConsume, lastEnrich, lastEnrichFail, lastWriteAsync, lastWrite, lastCFFail, lastFilter, lastLoggregatorError, lastLogMetric, lastLogMetricDropped, lastAggregate, lastAggregateLate, lastCardinalityLimit, lastHTTPCorrelated, lastHTTPUncorrelated, lastRouteCapped, lastMappingEmpty, lastSettingsFail uint64
 	for range time.Tick(1 * time.Second) {
 
 		s.l.Lock()
@@ -72,6 +124,18 @@ func (s *Stats) PerSec() {
 		s.WriteAsyncPerSec = s.WriteAsync - lastWriteAsync
 		s.WritePerSec = s.Write - lastWrite
 		s.CFFailPerSec = s.CFFail - lastCFFail
+		s.FilterPerSec = s.Filter - lastFilter
+		s.LoggregatorErrorPerSec = s.LoggregatorError - lastLoggregatorError
+		s.LogMetricPerSec = s.LogMetric - lastLogMetric
+		s.LogMetricDroppedPerSec = s.LogMetricDropped - lastLogMetricDropped
+		s.AggregatePerSec = s.Aggregate - lastAggregate
+		s.AggregateLatePerSec = s.AggregateLate - lastAggregateLate
+		s.CardinalityLimitPerSec = s.CardinalityLimit - lastCardinalityLimit
+		s.HTTPCorrelatedPerSec = s.HTTPCorrelated - lastHTTPCorrelated
+		s.HTTPUncorrelatedPerSec = s.HTTPUncorrelated - lastHTTPUncorrelated
+		s.RouteCappedPerSec = s.RouteCapped - lastRouteCapped
+		s.MappingEmptyPerSec = s.MappingEmpty - lastMappingEmpty
+		s.SettingsFailPerSec = s.SettingsFail - lastSettingsFail
 
 		lastConsume = s.Consume
 		lastEnrich = s.Enrich
@@ -79,6 +143,18 @@ func (s *Stats) PerSec() {
 		lastWriteAsync = s.WriteAsync
 		lastWrite = s.Write
 		lastCFFail = s.CFFail
+		lastFilter = s.Filter
+		lastLoggregatorError = s.LoggregatorError
+		lastLogMetric = s.LogMetric
+		lastLogMetricDropped = s.LogMetricDropped
+		lastAggregate = s.Aggregate
+		lastAggregateLate = s.AggregateLate
+		lastCardinalityLimit = s.CardinalityLimit
+		lastHTTPCorrelated = s.HTTPCorrelated
+		lastHTTPUncorrelated = s.HTTPUncorrelated
+		lastRouteCapped = s.RouteCapped
+		lastMappingEmpty = s.MappingEmpty
+		lastSettingsFail = s.SettingsFail
 
 		s.l.Unlock()
 	}
@@ -108,13 +184,71 @@ func (s *Stats) Inc(statsType StatsType, value int) {
 	case CFFail:
 		s.CFFail += v
 		s.LastCFFailTime = now
+	case Filter:
+		s.Filter += v
+		s.LastFilterTime = now
+	case LoggregatorError:
+		s.LoggregatorError += v
+		s.LastLoggregatorErrorTime = now
+	case LogMetric:
+		s.LogMetric += v
+		s.LastLogMetricTime = now
+	case LogMetricDropped:
+		s.LogMetricDropped += v
+		s.LastLogMetricDroppedTime = now
+	case Aggregate:
+		s.Aggregate += v
+		s.LastAggregateTime = now
+	case AggregateLate:
+		s.AggregateLate += v
+		s.LastAggregateLateTime = now
+	case CardinalityLimit:
+		s.CardinalityLimit += v
+		s.LastCardinalityLimitTime = now
+	case HTTPCorrelated:
+		s.HTTPCorrelated += v
+		s.LastHTTPCorrelatedTime = now
+	case HTTPUncorrelated:
+		s.HTTPUncorrelated += v
+		s.LastHTTPUncorrelatedTime = now
+	case RouteCapped:
+		s.RouteCapped += v
+		s.LastRouteCappedTime = now
+	case MappingEmpty:
+		s.MappingEmpty += v
+		s.LastMappingEmptyTime = now
+	case SettingsFail:
+		s.SettingsFail += v
+		s.LastSettingsFailTime = now
 	default:
 		s.l.Unlock()
-		panic(fmt.Sprintf("statsType is %s, not expected.", statsType))
+		panic(fmt.Sprintf("statsType is %d, not expected.", statsType))
 	}
 	s.l.Unlock()
 }
 
+// IncLogRule increments the match counter of a log rule.
+func (s *Stats) IncLogRule(rule string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.LogRules == nil {
+		s.LogRules = make(map[string]uint64)
+	}
+	s.LogRules[rule]++
+}
+
+// IncValidation increments the counter of a validation problem.
+func (s *Stats) IncValidation(reason string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.Validation == nil {
+		s.Validation = make(map[string]uint64)
+	}
+	s.Validation[reason]++
+}
+
 func (s *Stats) Json() ([]byte, error) {
 	s.l.Lock()
 	defer s.l.Unlock()
@@ -58,3 +58,24 @@ func TestStatsInc(t *testing.T) {
 		t.Fatalf("TestStatsInc: expect %d to be eq %d", s.Consume, expect)
 	}
 }
+
+func TestStatsIncLogRule(t *testing.T) {
+	s := NewStats()
+	s.IncLogRule("oom")
+	s.IncLogRule("oom")
+	s.IncLogRule("panic")
+
+	if s.LogRules["oom"] != 2 || s.LogRules["panic"] != 1 {
+		t.Fatalf("TestStatsIncLogRule: unexpected counters %v", s.LogRules)
+	}
+}
+
+func TestStatsIncValidation(t *testing.T) {
+	s := NewStats()
+	s.IncValidation("non_finite")
+	s.IncValidation("non_finite")
+
+	if s.Validation["non_finite"] != 2 {
+		t.Fatalf("TestStatsIncValidation: unexpected counters %v", s.Validation)
+	}
+}
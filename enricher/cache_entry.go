@@ -0,0 +1,14 @@
+package enricher
+
+import "time"
+
+// CacheEntry describes an entry of the in-memory metadata caches.
+type CacheEntry struct {
+	AppGUID string `json:"app_guid"`
+	// Metadata is the cached application metadata; it is nil for entries of
+	// the negative cache.
+	Metadata *AppMetadata `json:"metadata,omitempty"`
+	// LastSeen is when the entry was last queried (or, for the negative
+	// cache, when the application was last not found).
+	LastSeen time.Time `json:"last_seen"`
+}
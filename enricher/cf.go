@@ -11,11 +11,13 @@ import (
 )
 
 type CFClient struct {
-	c   *cfclient.Client
-	cfg ConfigCF
+	c          *cfclient.Client
+	cfg        ConfigCF
+	settingsCb Callback
 }
 
 type ConfigCF struct {
+	Name              string        `default:"default" desc:"Name of the Cloud Foundry foundation, used as foundation tag"` // CFMR_CF_NAME
 	API               string        `required:"true" desc:"URL of the Cloud Foundry API endpoint"`                          // CFMR_CF_API
 	User              string        `required:"true" desc:"Username for the Cloud Foundry API"`                             // CFMR_CF_USER
 	Password          string        `required:"true" desc:"Password for the Cloud Foundry API"`                             // CFMR_CF_PASSWORD
@@ -25,10 +27,13 @@ type ConfigCF struct {
 	Token             string        `desc:"Token for Cloud Foundry API"`                                                    // CFMR_CF_TOKEN
 	ClientID          string        `desc:"Client ID for Cloud Foundry API"`                                                // CFMR_CF_CLIENTID
 	ClientSecret      string        `desc:"Client secret for Cloud Foundry API"`                                            // CFMR_CF_CLIENTSECRET
+	FetchSettings     bool          `default:"false" desc:"Fetch the per-app settings from the app labels (v3 API)"`        // CFMR_CF_FETCHSETTINGS
 	UserAgent         string        `ignored:"true"`
 }
 
-func NewCFClient(cfg ConfigCF) (*CFClient, error) {
+// NewCFClient creates a CFClient. The callback, if not nil, is called when
+// the settings of an app can not be fetched.
+func NewCFClient(cfg ConfigCF, settingsCb Callback) (*CFClient, error) {
 	c, err := cfclient.NewClient(&cfclient.Config{
 		ApiAddress: cfg.API,
 		Username:   cfg.User,
@@ -50,13 +55,31 @@ func NewCFClient(cfg ConfigCF) (*CFClient, error) {
 		return nil, errors.Errorf("invalid value for ResultPerPage: %d", cfg.ResultsPerPage)
 	}
 
-	return &CFClient{c: c, cfg: cfg}, nil
+	return &CFClient{c: c, cfg: cfg, settingsCb: settingsCb}, nil
+}
+
+// Name returns the name of the foundation the client is connected to
+func (e *CFClient) Name() string {
+	return e.cfg.Name
 }
 
 // GetAppMetadata returns the metadata for the application with the specified GUID
 func (e *CFClient) GetAppMetadata(appGUID string) (AppMetadata, error) {
 	App, err := e.c.AppByGuid(appGUID)
 	if err != nil {
+		// the GUID of non-web v3 processes is not the one of their app: only
+		// look them up if the app was not found, not on timeouts or failures
+		if !cfclient.IsAppNotFoundError(err) {
+			return AppMetadata{}, errors.Wrap(err, "getting app metadata")
+		}
+		if p, perr := e.getProcess(appGUID); perr == nil && p.appGUID() != "" && p.appGUID() != appGUID {
+			md, err := e.GetAppMetadata(p.appGUID())
+			if err != nil {
+				return AppMetadata{}, err
+			}
+			md.ProcessType = p.Type
+			return md, nil
+		}
 		return AppMetadata{}, errors.Wrap(err, "getting app metadata")
 	}
 
@@ -70,14 +93,25 @@ func (e *CFClient) GetAppMetadata(appGUID string) (AppMetadata, error) {
 		return AppMetadata{}, errors.Wrap(err, "getting org metadata")
 	}
 
-	return AppMetadata{
-		App:       App.Name,
-		Space:     Space.Name,
-		Org:       Org.Name,
-		AppGUID:   App.Guid,
-		SpaceGUID: Space.Guid,
-		OrgGUID:   Org.Guid,
-	}, nil
+	md := AppMetadata{
+		App:        App.Name,
+		Space:      Space.Name,
+		Org:        Org.Name,
+		AppGUID:    App.Guid,
+		SpaceGUID:  Space.Guid,
+		OrgGUID:    Org.Guid,
+		Foundation: e.cfg.Name,
+	}
+
+	if e.cfg.FetchSettings {
+		// the settings are optional: a failure must not discard the events
+		md.Settings, err = e.getAppSettings(App.Guid)
+		if err != nil && e.settingsCb != nil {
+			e.settingsCb(err)
+		}
+	}
+
+	return md, nil
 }
 
 // GetRunningAppMetadata returns the metadata for all STARTED applications.
@@ -102,10 +136,22 @@ func (e *CFClient) GetRunningAppMetadata() ([]AppMetadata, error) {
 		return nil, errors.Wrap(err, "listing all apps")
 	}
 
-	return joinAppSpaceOrg(apps, spaces, orgs), nil
+	allAppMetadata := joinAppSpaceOrg(apps, spaces, orgs, e.cfg.Name)
+
+	if e.cfg.FetchSettings {
+		settings, err := e.listAppSettings()
+		if err != nil {
+			return nil, err
+		}
+		for i := range allAppMetadata {
+			allAppMetadata[i].Settings = settings[allAppMetadata[i].AppGUID]
+		}
+	}
+
+	return allAppMetadata, nil
 }
 
-func joinAppSpaceOrg(apps []cfclient.App, spaces []cfclient.Space, orgs []cfclient.Org) []AppMetadata {
+func joinAppSpaceOrg(apps []cfclient.App, spaces []cfclient.Space, orgs []cfclient.Org, foundation string) []AppMetadata {
 	orgmap := make(map[string]cfclient.Org, len(orgs))
 	for _, org := range orgs {
 		orgmap[org.Guid] = org
@@ -125,12 +171,13 @@ func joinAppSpaceOrg(apps []cfclient.App, spaces []cfclient.Space, orgs []cfclie
 		if space, found := spacemap[app.SpaceGuid]; found {
 			if org, found := orgmap[space.OrganizationGuid]; found {
 				allAppMetadata = append(allAppMetadata, AppMetadata{
-					App:       app.Name,
-					Space:     space.Name,
-					Org:       org.Name,
-					AppGUID:   app.Guid,
-					SpaceGUID: space.Guid,
-					OrgGUID:   org.Guid,
+					App:        app.Name,
+					Space:      space.Name,
+					Org:        org.Name,
+					AppGUID:    app.Guid,
+					SpaceGUID:  space.Guid,
+					OrgGUID:    org.Guid,
+					Foundation: foundation,
 				})
 			}
 		}
@@ -41,6 +41,9 @@ const (
 	spaceNameErr3 = "testSpaceErr3"
 	orgGuidErr3   = "20000000-0000-0000-0000-000000000003"
 
+	// Case: GUID of a non-web v3 process of the app appGuidOK
+	processGuidOK = "00000000-0000-0000-0000-000000000005"
+
 	// Case: App not started
 	appGuidNotStarted = "00000000-0000-0000-0000-000000000004"
 	appNameNotStarted = "testAppNotStarted"
@@ -63,19 +66,19 @@ func TestCFWarmupJoinerLogic(t *testing.T) {
 	spaces := []cfclient.Space{space, spaceB}
 	orgs := []cfclient.Org{org, orgB}
 
-	res := joinAppSpaceOrg(apps, spaces, orgs)
+	res := joinAppSpaceOrg(apps, spaces, orgs, "foundation")
 
 	if len(res) != 2 {
 		t.Fatal("unexpected number of app metadata")
 	}
 	if res[0].App != app1.Name || res[0].AppGUID != app1.Guid ||
 		res[0].Space != space.Name || res[0].SpaceGUID != space.Guid ||
-		res[0].Org != org.Name || res[0].OrgGUID != org.Guid {
+		res[0].Org != org.Name || res[0].OrgGUID != org.Guid || res[0].Foundation != "foundation" {
 		t.Fatalf("unexpected metadata for app1: %+v", res[0])
 	}
 	if res[1].App != app2.Name || res[1].AppGUID != app2.Guid ||
 		res[1].Space != space.Name || res[1].SpaceGUID != space.Guid ||
-		res[1].Org != org.Name || res[1].OrgGUID != org.Guid {
+		res[1].Org != org.Name || res[1].OrgGUID != org.Guid || res[1].Foundation != "foundation" {
 		t.Fatalf("unexpected metadata for app2: %+v", res[1])
 	}
 }
@@ -115,18 +118,15 @@ func setup() func() {
 		Password:          "test",
 		SkipSSLValidation: true,
 		ResultsPerPage:    100,
-	})
+	}, nil)
 
 	return func() {
 		server.Close()
 	}
 }
 
-func TestCFGetAppMetadata(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-
-	// Mock API for Case: AppGuid, SpaceGuid and OrgGuid exist
+// handleAppOK mocks the v2 API of the app, space and org that exist
+func handleAppOK() {
 	mux.HandleFunc("/v2/apps/"+appGuidOK, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -150,6 +150,14 @@ func TestCFGetAppMetadata(t *testing.T) {
 		org, _ := json.Marshal(orgR)
 		fmt.Fprint(w, string(org))
 	})
+}
+
+func TestCFGetAppMetadata(t *testing.T) {
+	teardown := setup()
+	defer teardown()
+
+	// Mock API for Case: AppGuid, SpaceGuid and OrgGuid exist
+	handleAppOK()
 
 	// Mock API for Error Case 1
 	mux.HandleFunc("/v2/apps/"+appGuidErr1, func(w http.ResponseWriter, r *http.Request) {
@@ -161,6 +169,19 @@ func TestCFGetAppMetadata(t *testing.T) {
 
 	})
 
+	// Mock API for Case: process GUID
+	mux.HandleFunc("/v2/apps/"+processGuidOK, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code": 100004, "error_code": "CF-AppNotFound"}`)
+	})
+
+	mux.HandleFunc("/v3/processes/"+processGuidOK, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"guid": "`+processGuidOK+`", "type": "worker", "relationships": {"app": {"data": {"guid": "`+appGuidOK+`"}}}}`)
+	})
+
 	// Mock API for Error Case 2
 	mux.HandleFunc("/v2/apps/"+appGuidErr2, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -194,6 +215,7 @@ func TestCFGetAppMetadata(t *testing.T) {
 		wantAppMetadata AppMetadata
 	}{
 		{"Get Metadata successfully", appGuidOK, false, AppMetadata{App: appNameOK, Space: spaceNameOK, Org: orgNameOK, AppGUID: appGuidOK, SpaceGUID: spaceGuidOK, OrgGUID: orgGuidOK}},
+		{"Get Process Metadata successfully", processGuidOK, false, AppMetadata{App: appNameOK, Space: spaceNameOK, Org: orgNameOK, AppGUID: appGuidOK, SpaceGUID: spaceGuidOK, OrgGUID: orgGuidOK, ProcessType: "worker"}},
 		{"Get App Metadata Error", appGuidErr1, true, AppMetadata{}},
 		{"Get Space Metadata Error", appGuidErr2, true, AppMetadata{}},
 		{"Get Org Metadata Error", appGuidErr3, true, AppMetadata{}},
@@ -254,3 +276,88 @@ func TestCFGetRunningAppMetadata(t *testing.T) {
 		t.Fatalf("TestCFGetRunningAppMetadata: expected %v, got %v, error = %v", wantAppMetadata, allAppMeta, err)
 	}
 }
+
+func TestCFAppSettings(t *testing.T) {
+	teardown := setup()
+	defer teardown()
+
+	cfClient.cfg.FetchSettings = true
+	cfClient.cfg.ResultsPerPage = 1
+
+	mux.HandleFunc("/v3/apps/"+appGuidOK, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"guid": "`+appGuidOK+`", "metadata": {"labels": {"metrics-refinery/logs": "off", "team": "a"}, "annotations": {"metrics-refinery/http-sample": "0.1"}}}`)
+	})
+
+	mux.HandleFunc("/v3/apps", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprint(w, `{"pagination": {"next": {"href": "`+server.URL+`/v3/apps?page=2&per_page=1"}}, "resources": [{"guid": "`+appGuidOK+`", "metadata": {"labels": {"metrics-refinery/logs": "off"}}}]}`)
+		case "2":
+			fmt.Fprint(w, `{"pagination": {"next": null}, "resources": [{"guid": "`+appGuidNotStarted+`", "metadata": {"labels": {}}}]}`)
+		}
+	})
+
+	settings, err := cfClient.getAppSettings(appGuidOK)
+	want := map[string]string{"logs": "off", "http-sample": "0.1"}
+	if !reflect.DeepEqual(settings, want) || err != nil {
+		t.Fatalf("TestCFAppSettings: expected %v, got %v, error = %v", want, settings, err)
+	}
+
+	all, err := cfClient.listAppSettings()
+	wantAll := map[string]map[string]string{appGuidOK: {"logs": "off"}}
+	if !reflect.DeepEqual(all, wantAll) || err != nil {
+		t.Fatalf("TestCFAppSettings: expected %v, got %v, error = %v", wantAll, all, err)
+	}
+}
+
+func TestCFAppSettingsFailure(t *testing.T) {
+	teardown := setup()
+	defer teardown()
+
+	cfClient.cfg.FetchSettings = true
+	failures := 0
+	cfClient.settingsCb = func(err error) {
+		failures++
+	}
+
+	handleAppOK()
+	mux.HandleFunc("/v3/apps/"+appGuidOK, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	md, err := cfClient.GetAppMetadata(appGuidOK)
+	if err != nil || md.App != appNameOK || md.Settings != nil {
+		t.Fatalf("TestCFAppSettingsFailure: expected the metadata without settings, got %v, error = %v", md, err)
+	}
+	if failures != 1 {
+		t.Fatalf("TestCFAppSettingsFailure: expected 1 settings failure, got %d", failures)
+	}
+}
+
+func TestCFAppLookupFailure(t *testing.T) {
+	teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/v2/apps/"+processGuidOK, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"code": 10001, "error_code": "CF-UnknownError"}`)
+	})
+	lookups := 0
+	mux.HandleFunc("/v3/processes/"+processGuidOK, func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	md, err := cfClient.GetAppMetadata(processGuidOK)
+	if err == nil || md.App != "" {
+		t.Fatalf("TestCFAppLookupFailure: expected an error, got %v", md)
+	}
+	if lookups != 0 {
+		t.Fatalf("TestCFAppLookupFailure: expected no process lookup, got %d", lookups)
+	}
+}
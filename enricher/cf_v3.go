@@ -0,0 +1,102 @@
+package enricher
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// v3Metadata is the metadata section of v3 CF API resources
+type v3Metadata struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type v3App struct {
+	GUID     string     `json:"guid"`
+	Metadata v3Metadata `json:"metadata"`
+}
+
+type v3AppList struct {
+	Pagination struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Resources []v3App `json:"resources"`
+}
+
+type v3Process struct {
+	GUID          string `json:"guid"`
+	Type          string `json:"type"`
+	Relationships struct {
+		App struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"app"`
+	} `json:"relationships"`
+}
+
+// appGUID returns the GUID of the app the process belongs to
+func (p v3Process) appGUID() string {
+	return p.Relationships.App.Data.GUID
+}
+
+// getV3 GETs the specified v3 API path and decodes the JSON response in out.
+func (e *CFClient) getV3(path string, out interface{}) error {
+	resp, err := e.c.DoRequest(e.c.NewRequest("GET", path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// getAppSettings returns the refinery settings of the app with the specified GUID
+func (e *CFClient) getAppSettings(appGUID string) (map[string]string, error) {
+	var app v3App
+	if err := e.getV3("/v3/apps/"+appGUID, &app); err != nil {
+		return nil, errors.Wrap(err, "getting app labels")
+	}
+	return parseSettings(app.Metadata), nil
+}
+
+// getProcess returns the v3 process with the specified GUID
+func (e *CFClient) getProcess(guid string) (v3Process, error) {
+	var p v3Process
+	if err := e.getV3("/v3/processes/"+guid, &p); err != nil {
+		return v3Process{}, errors.Wrap(err, "getting process")
+	}
+	return p, nil
+}
+
+// listAppSettings returns the refinery settings of all apps that have any,
+// indexed by app GUID
+func (e *CFClient) listAppSettings() (map[string]map[string]string, error) {
+	settings := make(map[string]map[string]string)
+	path := "/v3/apps?per_page=" + strconv.Itoa(e.cfg.ResultsPerPage)
+	for path != "" {
+		var apps v3AppList
+		if err := e.getV3(path, &apps); err != nil {
+			return nil, errors.Wrap(err, "listing app labels")
+		}
+		for _, app := range apps.Resources {
+			if s := parseSettings(app.Metadata); s != nil {
+				settings[app.GUID] = s
+			}
+		}
+
+		path = ""
+		if apps.Pagination.Next != nil && apps.Pagination.Next.Href != "" {
+			next, err := url.Parse(apps.Pagination.Next.Href)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing next page URL")
+			}
+			path = next.RequestURI()
+		}
+	}
+	return settings, nil
+}
@@ -11,4 +11,18 @@ type AppMetadata struct {
 	AppGUID   string
 	SpaceGUID string
 	OrgGUID   string
+
+	// ProcessType is the type of the v3 process (e.g. "worker") if the GUID
+	// looked up is the one of a process rather than of an app
+	ProcessType string
+
+	// Foundation is the name of the CF foundation the app is running on
+	Foundation string
+
+	// Tags are additional tags to add to all points of the app
+	Tags map[string]string
+
+	// Settings are the per-app settings taken from the CF labels and
+	// annotations prefixed by SettingsPrefix (with the prefix removed)
+	Settings map[string]string
 }
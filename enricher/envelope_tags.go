@@ -0,0 +1,75 @@
+package enricher
+
+// Envelope tags set by loggregator agents and nozzles that already know the
+// metadata of the application that emitted the envelope.
+const (
+	TagAppName   = "app_name"
+	TagAppGUID   = "app_id"
+	TagSpaceName = "space_name"
+	TagSpaceGUID = "space_id"
+	TagOrgName   = "organization_name"
+	TagOrgGUID   = "organization_id"
+
+	// Process tags of v2 envelopes emitted by app instances.
+	TagProcessType       = "process_type"
+	TagProcessInstanceID = "process_instance_id"
+)
+
+// TagsEnricher is implemented by Enrichers that can use the tags of the
+// envelope to resolve the application metadata.
+type TagsEnricher interface {
+	GetAppMetadataWithTags(appGUID string, tags map[string]string) (AppMetadata, error)
+}
+
+// EnvelopeTags is an Enricher that builds the application metadata directly
+// from the envelope tags, if they contain all the required metadata. Lookups
+// for envelopes without them are passed to the parent Enricher.
+type EnvelopeTags struct {
+	parent     Enricher
+	foundation string
+	settings   bool
+}
+
+// NewEnvelopeTags creates an EnvelopeTags that uses the provided parent
+// Enricher to resolve envelopes without metadata tags. The foundation name is
+// added to the metadata built from the tags. If settings is true, the
+// per-app settings, which are not in the tags, are taken from the parent
+// Enricher.
+func NewEnvelopeTags(parent Enricher, foundation string, settings bool) *EnvelopeTags {
+	return &EnvelopeTags{parent: parent, foundation: foundation, settings: settings}
+}
+
+// GetAppMetadata queries the parent Enricher.
+func (e *EnvelopeTags) GetAppMetadata(appGUID string) (AppMetadata, error) {
+	return e.parent.GetAppMetadata(appGUID)
+}
+
+// GetAppMetadataWithTags returns the application metadata contained in the
+// tags if all of them are present and they refer to the specified application
+// GUID; otherwise the parent Enricher is queried.
+func (e *EnvelopeTags) GetAppMetadataWithTags(appGUID string, tags map[string]string) (AppMetadata, error) {
+	md := AppMetadata{
+		App:         tags[TagAppName],
+		AppGUID:     tags[TagAppGUID],
+		Space:       tags[TagSpaceName],
+		SpaceGUID:   tags[TagSpaceGUID],
+		Org:         tags[TagOrgName],
+		OrgGUID:     tags[TagOrgGUID],
+		Foundation:  e.foundation,
+		ProcessType: tags[TagProcessType],
+	}
+	if md.AppGUID != appGUID || md.App == "" || md.Space == "" || md.SpaceGUID == "" || md.Org == "" || md.OrgGUID == "" {
+		return e.parent.GetAppMetadata(appGUID)
+	}
+	if e.settings {
+		// the metadata in the tags is still used if the lookup fails: the
+		// settings are optional
+		if pmd, err := e.parent.GetAppMetadata(appGUID); err == nil {
+			md.Settings = pmd.Settings
+			if md.ProcessType == "" {
+				md.ProcessType = pmd.ProcessType
+			}
+		}
+	}
+	return md, nil
+}
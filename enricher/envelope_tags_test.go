@@ -0,0 +1,72 @@
+package enricher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvelopeTags_GetAppMetadataWithTags(t *testing.T) {
+	fullTags := map[string]string{
+		TagAppName:   "tagapp",
+		TagAppGUID:   "guid1",
+		TagSpaceName: "tagspace",
+		TagSpaceGUID: "spaceguid1",
+		TagOrgName:   "tagorg",
+		TagOrgGUID:   "orgguid1",
+	}
+	partialTags := map[string]string{
+		TagAppName: "tagapp",
+		TagAppGUID: "guid1",
+	}
+	fromTags := AppMetadata{
+		App:        "tagapp",
+		AppGUID:    "guid1",
+		Space:      "tagspace",
+		SpaceGUID:  "spaceguid1",
+		Org:        "tagorg",
+		OrgGUID:    "orgguid1",
+		Foundation: "east",
+	}
+	withSettings := mockData("guid1")
+	withSettings.Settings = map[string]string{"logs": "off"}
+	withSettings.ProcessType = "worker"
+	fromTagsWithSettings := fromTags
+	fromTagsWithSettings.Settings = withSettings.Settings
+	fromTagsWithSettings.ProcessType = "worker"
+	processTags := map[string]string{TagProcessType: "web"}
+	for k, v := range fullTags {
+		processTags[k] = v
+	}
+	fromProcessTags := fromTagsWithSettings
+	fromProcessTags.ProcessType = "web"
+
+	tests := []struct {
+		name     string
+		parent   Enricher
+		settings bool
+		appGUID  string
+		tags     map[string]string
+		want     AppMetadata
+		wantErr  bool
+	}{
+		{"all tags", mockEnricher("guid1"), false, "guid1", fullTags, fromTags, false},
+		{"partial tags", mockEnricher("guid1"), false, "guid1", partialTags, mockData("guid1"), false},
+		{"no tags", mockEnricher("guid1"), false, "guid1", nil, mockData("guid1"), false},
+		{"tags for another app", mockEnricher("guid1"), false, "guid2", fullTags, AppMetadata{}, true},
+		{"settings from parent", &me{map[string]AppMetadata{"guid1": withSettings}}, true, "guid1", fullTags, fromTagsWithSettings, false},
+		{"process type tag", &me{map[string]AppMetadata{"guid1": withSettings}}, true, "guid1", processTags, fromProcessTags, false},
+		{"settings lookup failure", mockEnricher(), true, "guid1", fullTags, fromTags, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEnvelopeTags(tt.parent, "east", tt.settings)
+			got, err := e.GetAppMetadataWithTags(tt.appGUID, tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EnvelopeTags.GetAppMetadataWithTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("EnvelopeTags.GetAppMetadataWithTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
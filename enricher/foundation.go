@@ -0,0 +1,131 @@
+package enricher
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConfigFoundation is the configuration of an additional CF foundation. Events
+// are routed to a foundation if they were read from one of its Topics or if
+// their envelope carries one of its Deployments.
+type ConfigFoundation struct {
+	ConfigCF
+	Topics      []string
+	Deployments []string
+}
+
+// ConfigFoundations is the list of additional CF foundations. It is configured
+// as a JSON list, e.g.
+//
+//	[{"name": "east", "api": "https://api.east.example.com", "user": "...",
+//	  "password": "...", "timeout": "30s", "deployments": ["cf-east"]}]
+//
+// Keys match the ones of ConfigCF (case-insensitive), plus "topics" and
+// "deployments". Unset values take the same defaults as CFMR_CF_*.
+type ConfigFoundations []ConfigFoundation
+
+// Decode implements envconfig.Decoder
+func (f *ConfigFoundations) Decode(value string) error {
+	var raw []struct {
+		ConfigFoundation
+		Timeout        string
+		ResultsPerPage *int
+	}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return errors.Wrap(err, "parsing foundations")
+	}
+
+	foundations := make(ConfigFoundations, 0, len(raw))
+	names := make(map[string]bool, len(raw))
+	for _, r := range raw {
+		cfg := r.ConfigFoundation
+		if cfg.Name == "" {
+			return errors.New("foundation without name")
+		}
+		if names[cfg.Name] {
+			return errors.Errorf("duplicated foundation %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		cfg.Timeout = 1 * time.Minute
+		if r.Timeout != "" {
+			timeout, err := time.ParseDuration(r.Timeout)
+			if err != nil {
+				return errors.Wrapf(err, "parsing timeout of foundation %q", cfg.Name)
+			}
+			cfg.Timeout = timeout
+		}
+		cfg.ResultsPerPage = 50
+		if r.ResultsPerPage != nil {
+			cfg.ResultsPerPage = *r.ResultsPerPage
+		}
+		foundations = append(foundations, cfg)
+	}
+
+	*f = foundations
+	return nil
+}
+
+// Router is implemented by Enrichers that delegate lookups to different
+// Enrichers depending on where an envelope comes from.
+type Router interface {
+	// Route returns the Enricher to use for envelopes read from the
+	// specified source (e.g. a Kafka topic) and carrying the specified
+	// deployment name.
+	Route(source, deployment string) Enricher
+}
+
+// FoundationRouter routes lookups to the enricher chain of the CF foundation
+// an envelope originates from. Envelopes are matched first by deployment, then
+// by source; envelopes matching no foundation are looked up in the default
+// Enricher.
+type FoundationRouter struct {
+	def          Enricher
+	bySource     map[string]Enricher
+	byDeployment map[string]Enricher
+}
+
+// NewFoundationRouter creates a FoundationRouter using def as the default
+// Enricher.
+func NewFoundationRouter(def Enricher) *FoundationRouter {
+	return &FoundationRouter{
+		def:          def,
+		bySource:     make(map[string]Enricher),
+		byDeployment: make(map[string]Enricher),
+	}
+}
+
+// Add routes envelopes from the specified sources and deployments to e.
+func (r *FoundationRouter) Add(e Enricher, sources, deployments []string) error {
+	for _, s := range sources {
+		if _, found := r.bySource[s]; found {
+			return errors.Errorf("source %q is already routed", s)
+		}
+		r.bySource[s] = e
+	}
+	for _, d := range deployments {
+		if _, found := r.byDeployment[d]; found {
+			return errors.Errorf("deployment %q is already routed", d)
+		}
+		r.byDeployment[d] = e
+	}
+	return nil
+}
+
+// Route implements Router
+func (r *FoundationRouter) Route(source, deployment string) Enricher {
+	if e, found := r.byDeployment[deployment]; found && deployment != "" {
+		return e
+	}
+	if e, found := r.bySource[source]; found && source != "" {
+		return e
+	}
+	return r.def
+}
+
+// GetAppMetadata looks up the app in the default Enricher.
+func (r *FoundationRouter) GetAppMetadata(appGUID string) (AppMetadata, error) {
+	return r.def.GetAppMetadata(appGUID)
+}
@@ -0,0 +1,84 @@
+package enricher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigFoundationsDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    ConfigFoundations
+		wantErr bool
+	}{
+		{"empty", `[]`, ConfigFoundations{}, false},
+		{"defaults", `[{"name": "east", "api": "https://api.east", "user": "u", "password": "p", "deployments": ["cf-east"]}]`, ConfigFoundations{
+			{ConfigCF: ConfigCF{Name: "east", API: "https://api.east", User: "u", Password: "p", Timeout: time.Minute, ResultsPerPage: 50}, Deployments: []string{"cf-east"}},
+		}, false},
+		{"overrides", `[{"name": "west", "timeout": "10s", "resultsperpage": 10, "skipsslvalidation": true, "topics": ["west-logs"]}]`, ConfigFoundations{
+			{ConfigCF: ConfigCF{Name: "west", Timeout: 10 * time.Second, ResultsPerPage: 10, SkipSSLValidation: true}, Topics: []string{"west-logs"}},
+		}, false},
+		{"missing name", `[{"api": "https://api.east"}]`, nil, true},
+		{"duplicated name", `[{"name": "east"}, {"name": "east"}]`, nil, true},
+		{"invalid timeout", `[{"name": "east", "timeout": "soon"}]`, nil, true},
+		{"invalid json", `{`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ConfigFoundations
+			err := got.Decode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigFoundations.Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ConfigFoundations.Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoundationRouter(t *testing.T) {
+	def := mockEnricher("guid0")
+	east := mockEnricher("guid1")
+	west := mockEnricher("guid2")
+
+	r := NewFoundationRouter(def)
+	if err := r.Add(east, []string{"east-topic"}, []string{"cf-east"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(west, []string{"west-topic"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(west, nil, []string{"cf-east"}); err == nil {
+		t.Fatal("expected error routing the same deployment twice")
+	}
+	if err := r.Add(west, []string{"east-topic"}, nil); err == nil {
+		t.Fatal("expected error routing the same source twice")
+	}
+
+	tests := []struct {
+		name       string
+		source     string
+		deployment string
+		want       Enricher
+	}{
+		{"by deployment", "", "cf-east", east},
+		{"deployment wins over source", "west-topic", "cf-east", east},
+		{"by source", "west-topic", "cf-unknown", west},
+		{"default", "other-topic", "cf-unknown", def},
+		{"empty", "", "", def},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Route(tt.source, tt.deployment); got != tt.want {
+				t.Fatalf("FoundationRouter.Route() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if md, err := r.GetAppMetadata("guid0"); err != nil || !reflect.DeepEqual(md, mockData("guid0")) {
+		t.Fatalf("FoundationRouter.GetAppMetadata() = %v, %v", md, err)
+	}
+}
@@ -85,3 +85,59 @@ func (e *MemLRUCache) Warmup(mds []AppMetadata) {
 		}
 	}
 }
+
+// Lookup returns the cache entry for the specified application GUID, without
+// updating its last seen time.
+func (e *MemLRUCache) Lookup(appGUID string) (CacheEntry, bool) {
+	e.Lock()
+	defer e.Unlock()
+	amd, ok := e.cache[appGUID]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	return amd.entry(appGUID), true
+}
+
+// Entries returns a snapshot of all the entries in the cache.
+func (e *MemLRUCache) Entries() []CacheEntry {
+	e.Lock()
+	defer e.Unlock()
+	entries := make([]CacheEntry, 0, len(e.cache))
+	for guid, amd := range e.cache {
+		entries = append(entries, amd.entry(guid))
+	}
+	return entries
+}
+
+// Invalidate removes the specified application GUID from the cache, together
+// with the entries of the processes of the application. It returns false if
+// nothing was cached.
+func (e *MemLRUCache) Invalidate(appGUID string) bool {
+	e.Lock()
+	defer e.Unlock()
+	ok := false
+	for k, amd := range e.cache {
+		if k == appGUID || amd.AppGUID == appGUID {
+			delete(e.cache, k)
+			ok = true
+		}
+	}
+	return ok
+}
+
+// InvalidateAll removes all entries from the cache and returns how many
+// entries were removed.
+func (e *MemLRUCache) InvalidateAll() int {
+	e.Lock()
+	defer e.Unlock()
+	n := len(e.cache)
+	e.cache = make(map[string]*appMetadata)
+	return n
+}
+
+// entry returns the cache entry of the GUID, which is the one of a process
+// instead of its app for non-web processes.
+func (amd *appMetadata) entry(guid string) CacheEntry {
+	md := amd.AppMetadata
+	return CacheEntry{AppGUID: guid, Metadata: &md, LastSeen: amd.lastSeen}
+}
@@ -163,3 +163,37 @@ func TestMemLRUCache_WarmupExsitingCache(t *testing.T) {
 		t.Fatalf("TestMemLRUCache_WarmupExsitingCache: expect guid2 Metadata %v, got %v \n", wantAppMetadataGUID2, em.cache["guid2"].AppMetadata)
 	}
 }
+
+func TestMemLRUCache_Inspect(t *testing.T) {
+	em := NewMemLRUCache(nil).(*MemLRUCache)
+	em.Warmup([]AppMetadata{mockData("guid1"), mockData("guid2")})
+
+	entry, ok := em.Lookup("guid1")
+	if !ok || entry.AppGUID != "guid1" || entry.Metadata == nil || !reflect.DeepEqual(*entry.Metadata, mockData("guid1")) || entry.LastSeen.IsZero() {
+		t.Fatalf("TestMemLRUCache_Inspect: unexpected entry %+v", entry)
+	}
+	if _, ok := em.Lookup("guid3"); ok {
+		t.Fatal("TestMemLRUCache_Inspect: unexpected entry for guid3")
+	}
+	if entries := em.Entries(); len(entries) != 2 {
+		t.Fatalf("TestMemLRUCache_Inspect: expected 2 entries, got %v", entries)
+	}
+	// entries of non-web processes are listed by process GUID
+	em.cache["process1"] = &appMetadata{AppMetadata: mockData("guid1")}
+	if entry, ok := em.Lookup("process1"); !ok || entry.AppGUID != "process1" || entry.Metadata.AppGUID != "guid1" {
+		t.Fatalf("TestMemLRUCache_Inspect: unexpected entry %+v", entry)
+	}
+
+	if !em.Invalidate("guid1") || em.Invalidate("guid1") {
+		t.Fatal("TestMemLRUCache_Inspect: unexpected result invalidating guid1")
+	}
+	if _, ok := em.Lookup("guid1"); ok {
+		t.Fatal("TestMemLRUCache_Inspect: guid1 still cached")
+	}
+	if _, ok := em.Lookup("process1"); ok {
+		t.Fatal("TestMemLRUCache_Inspect: the process of guid1 still cached")
+	}
+	if n := em.InvalidateAll(); n != 1 || len(em.Entries()) != 0 {
+		t.Fatalf("TestMemLRUCache_Inspect: expected 1 entry invalidated, got %d", n)
+	}
+}
@@ -81,3 +81,45 @@ func (nm *NegativeMemLRUCache) Warmup(mds []AppMetadata) {
 		}
 	}
 }
+
+// Lookup returns the negative cache entry for the specified application GUID.
+func (nm *NegativeMemLRUCache) Lookup(appGUID string) (CacheEntry, bool) {
+	nm.Lock()
+	defer nm.Unlock()
+	anf, ok := nm.cache[appGUID]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	return CacheEntry{AppGUID: appGUID, LastSeen: anf.lastNotFound}, true
+}
+
+// Entries returns a snapshot of all the entries in the negative cache.
+func (nm *NegativeMemLRUCache) Entries() []CacheEntry {
+	nm.Lock()
+	defer nm.Unlock()
+	entries := make([]CacheEntry, 0, len(nm.cache))
+	for appGUID, anf := range nm.cache {
+		entries = append(entries, CacheEntry{AppGUID: appGUID, LastSeen: anf.lastNotFound})
+	}
+	return entries
+}
+
+// Invalidate removes the specified application GUID from the negative cache.
+// It returns false if the application was not in the negative cache.
+func (nm *NegativeMemLRUCache) Invalidate(appGUID string) bool {
+	nm.Lock()
+	defer nm.Unlock()
+	_, ok := nm.cache[appGUID]
+	delete(nm.cache, appGUID)
+	return ok
+}
+
+// InvalidateAll removes all entries from the negative cache and returns how
+// many entries were removed.
+func (nm *NegativeMemLRUCache) InvalidateAll() int {
+	nm.Lock()
+	defer nm.Unlock()
+	n := len(nm.cache)
+	nm.cache = make(map[string]*appNotFound)
+	return n
+}
@@ -124,3 +124,26 @@ func TestNegativeMemLRUCache_Warmup(t *testing.T) {
 		}
 	}
 }
+
+func TestNegativeMemLRUCache_Inspect(t *testing.T) {
+	nm := NewNegativeMemLRUCache(nil).(*NegativeMemLRUCache)
+	nm.cache = mockNegativeCache("guid1", "guid2")
+
+	entry, ok := nm.Lookup("guid1")
+	if !ok || entry.AppGUID != "guid1" || entry.Metadata != nil || entry.LastSeen.IsZero() {
+		t.Fatalf("TestNegativeMemLRUCache_Inspect: unexpected entry %+v", entry)
+	}
+	if _, ok := nm.Lookup("guid3"); ok {
+		t.Fatal("TestNegativeMemLRUCache_Inspect: unexpected entry for guid3")
+	}
+	if entries := nm.Entries(); len(entries) != 2 {
+		t.Fatalf("TestNegativeMemLRUCache_Inspect: expected 2 entries, got %v", entries)
+	}
+
+	if !nm.Invalidate("guid1") || nm.Invalidate("guid1") {
+		t.Fatal("TestNegativeMemLRUCache_Inspect: unexpected result invalidating guid1")
+	}
+	if n := nm.InvalidateAll(); n != 1 || len(nm.Entries()) != 0 {
+		t.Fatalf("TestNegativeMemLRUCache_Inspect: expected 1 entry invalidated, got %d", n)
+	}
+}
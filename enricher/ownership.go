@@ -0,0 +1,215 @@
+package enricher
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type ConfigOwnership struct {
+	File           string        `desc:"Path of the JSON or CSV file mapping org/space/app names to their owners"` // CFMR_OWNERSHIP_FILE
+	Tags           []string      `default:"team" desc:"Ownership attributes to add as tags to all points"`         // CFMR_OWNERSHIP_TAGS
+	ReloadInterval time.Duration `default:"1m" desc:"How often to check the ownership file for changes"`           // CFMR_OWNERSHIP_RELOADINTERVAL
+}
+
+// OwnershipRule assigns the ownership attributes (e.g. team, cost center,
+// contact) to all apps whose org, space and app names match the respective
+// glob patterns. An empty pattern matches any name.
+type OwnershipRule struct {
+	Org        string            `json:"org"`
+	Space      string            `json:"space"`
+	App        string            `json:"app"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func (r *OwnershipRule) matches(md AppMetadata) bool {
+	return globMatch(r.Org, md.Org) && globMatch(r.Space, md.Space) && globMatch(r.App, md.App)
+}
+
+func globMatch(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// OwnershipMapping is the list of OwnershipRules loaded from a file. The file
+// is either a JSON list of OwnershipRules or, if its extension is .csv, a CSV
+// file with a header containing the "org", "space" and "app" columns followed
+// by the names of the attributes. Rules are evaluated in order and the first
+// matching rule wins.
+type OwnershipMapping struct {
+	path string
+
+	l       sync.RWMutex
+	rules   []OwnershipRule
+	modTime time.Time
+}
+
+// NewOwnershipMapping loads the ownership mapping from the specified file.
+func NewOwnershipMapping(path string) (*OwnershipMapping, error) {
+	m := &OwnershipMapping{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload loads the ownership mapping from the file. If loading fails, the
+// previous mapping is kept.
+func (m *OwnershipMapping) Reload() error {
+	fi, err := os.Stat(m.path)
+	if err != nil {
+		return errors.Wrap(err, "reading ownership mapping")
+	}
+
+	f, err := os.Open(m.path)
+	if err != nil {
+		return errors.Wrap(err, "reading ownership mapping")
+	}
+	defer f.Close()
+
+	var rules []OwnershipRule
+	if strings.EqualFold(filepath.Ext(m.path), ".csv") {
+		rules, err = parseOwnershipCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(&rules)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "parsing ownership mapping %s", m.path)
+	}
+
+	m.l.Lock()
+	m.rules = rules
+	m.modTime = fi.ModTime()
+	m.l.Unlock()
+	return nil
+}
+
+// ReloadIfChanged reloads the ownership mapping if the file was modified
+// since the last time it was loaded. It returns true if it was reloaded.
+func (m *OwnershipMapping) ReloadIfChanged() (bool, error) {
+	fi, err := os.Stat(m.path)
+	if err != nil {
+		return false, errors.Wrap(err, "reading ownership mapping")
+	}
+
+	m.l.RLock()
+	changed := !fi.ModTime().Equal(m.modTime)
+	m.l.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, m.Reload()
+}
+
+// Match returns the attributes of the first rule matching the application.
+func (m *OwnershipMapping) Match(md AppMetadata) map[string]string {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	for i := range m.rules {
+		if m.rules[i].matches(md) {
+			return m.rules[i].Attributes
+		}
+	}
+	return nil
+}
+
+func parseOwnershipCSV(r io.Reader) ([]OwnershipRule, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	var rules []OwnershipRule
+	for _, record := range records[1:] {
+		rule := OwnershipRule{Attributes: make(map[string]string)}
+		for i, v := range record {
+			switch col := strings.TrimSpace(header[i]); col {
+			case "org":
+				rule.Org = v
+			case "space":
+				rule.Space = v
+			case "app":
+				rule.App = v
+			default:
+				if v != "" {
+					rule.Attributes[col] = v
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Ownership is an Enricher that adds the selected ownership attributes of
+// the app, as defined in an OwnershipMapping, to the tags of the metadata
+// returned by the parent Enricher.
+type Ownership struct {
+	parent  Enricher
+	mapping *OwnershipMapping
+	tags    []string
+}
+
+// NewOwnership creates an Ownership that adds the specified attributes
+// as tags to the metadata returned by the parent Enricher.
+func NewOwnership(parent Enricher, mapping *OwnershipMapping, tags []string) *Ownership {
+	return &Ownership{parent: parent, mapping: mapping, tags: tags}
+}
+
+// GetAppMetadata queries the parent Enricher and adds the ownership tags.
+func (o *Ownership) GetAppMetadata(appGUID string) (AppMetadata, error) {
+	md, err := o.parent.GetAppMetadata(appGUID)
+	if err != nil {
+		return md, err
+	}
+	return o.addTags(md), nil
+}
+
+// GetAppMetadataWithTags implements TagsEnricher, passing the envelope tags
+// to the parent Enricher if it supports them.
+func (o *Ownership) GetAppMetadataWithTags(appGUID string, tags map[string]string) (AppMetadata, error) {
+	te, ok := o.parent.(TagsEnricher)
+	if !ok {
+		return o.GetAppMetadata(appGUID)
+	}
+	md, err := te.GetAppMetadataWithTags(appGUID, tags)
+	if err != nil {
+		return md, err
+	}
+	return o.addTags(md), nil
+}
+
+func (o *Ownership) addTags(md AppMetadata) AppMetadata {
+	attrs := o.mapping.Match(md)
+	if len(attrs) == 0 {
+		return md
+	}
+
+	// the metadata may be shared with the cache, so never modify its tags
+	tags := make(map[string]string, len(md.Tags)+len(o.tags))
+	for k, v := range md.Tags {
+		tags[k] = v
+	}
+	for _, k := range o.tags {
+		if v, found := attrs[k]; found {
+			tags[k] = v
+		}
+	}
+	md.Tags = tags
+	return md
+}
@@ -0,0 +1,127 @@
+package enricher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const ownershipJSON = `[
+	{"org": "payments", "space": "prod", "app": "api-*", "attributes": {"team": "payments-api", "contact": "api@sample.com"}},
+	{"org": "payments", "attributes": {"team": "payments", "cost_center": "CC-1"}}
+]`
+
+const ownershipCSV = `org,space,app,team,cost_center
+payments,prod,api-*,payments-api,
+payments,,,payments,CC-1
+`
+
+func writeTempFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "ownership")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p, func() { os.RemoveAll(dir) }
+}
+
+func TestOwnershipMapping_Match(t *testing.T) {
+	for _, file := range []struct{ name, content string }{
+		{"ownership.json", ownershipJSON},
+		{"ownership.csv", ownershipCSV},
+	} {
+		p, cleanup := writeTempFile(t, file.name, file.content)
+		defer cleanup()
+
+		m, err := NewOwnershipMapping(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			md       AppMetadata
+			wantTeam string
+		}{
+			{AppMetadata{Org: "payments", Space: "prod", App: "api-v2"}, "payments-api"},
+			{AppMetadata{Org: "payments", Space: "dev", App: "api-v2"}, "payments"},
+			{AppMetadata{Org: "search", Space: "prod", App: "api-v2"}, ""},
+		}
+		for _, tt := range tests {
+			if got := m.Match(tt.md)["team"]; got != tt.wantTeam {
+				t.Fatalf("%s: OwnershipMapping.Match(%v) team = %q, want %q", file.name, tt.md, got, tt.wantTeam)
+			}
+		}
+	}
+}
+
+func TestOwnershipMapping_ReloadIfChanged(t *testing.T) {
+	p, cleanup := writeTempFile(t, "ownership.json", ownershipJSON)
+	defer cleanup()
+
+	m, err := NewOwnershipMapping(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := m.ReloadIfChanged(); reloaded || err != nil {
+		t.Fatalf("unexpected reload: %v %v", reloaded, err)
+	}
+
+	if err := ioutil.WriteFile(p, []byte(`[{"attributes": {"team": "everyone"}}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(p, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := m.ReloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("expected reload: %v %v", reloaded, err)
+	}
+	if got := m.Match(AppMetadata{Org: "search"})["team"]; got != "everyone" {
+		t.Fatalf("unexpected team %q after reload", got)
+	}
+
+	// a broken file keeps the previous mapping
+	if err := ioutil.WriteFile(p, []byte(`[{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatal("expected error reloading broken file")
+	}
+	if got := m.Match(AppMetadata{Org: "search"})["team"]; got != "everyone" {
+		t.Fatalf("unexpected team %q after failed reload", got)
+	}
+}
+
+func TestOwnership_GetAppMetadata(t *testing.T) {
+	p, cleanup := writeTempFile(t, "ownership.json", `[{"org": "orgguid1", "attributes": {"team": "t1", "contact": "c1"}}]`)
+	defer cleanup()
+
+	m, err := NewOwnershipMapping(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOwnership(mockEnricher("guid1", "guid2"), m, []string{"team", "cost_center"})
+
+	md, err := o.GetAppMetadata("guid1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"team": "t1"}; !reflect.DeepEqual(md.Tags, want) {
+		t.Fatalf("Ownership.GetAppMetadata() tags = %v, want %v", md.Tags, want)
+	}
+
+	md, err = o.GetAppMetadata("guid2")
+	if err != nil || md.Tags != nil {
+		t.Fatalf("Ownership.GetAppMetadata() tags = %v, %v", md.Tags, err)
+	}
+
+	if _, err := o.GetAppMetadata("guid3"); err == nil {
+		t.Fatal("expected error for unknown app")
+	}
+}
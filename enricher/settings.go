@@ -0,0 +1,58 @@
+package enricher
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SettingsPrefix is the prefix of the CF labels and annotations that control
+// how cf-metrics-refinery handles the events of an app, e.g.
+// "metrics-refinery/logs=off" or "metrics-refinery/http-sample=0.1".
+const SettingsPrefix = "metrics-refinery/"
+
+// Event classes that can be sampled (or disabled) per app.
+const (
+	ClassLogs      = "logs"
+	ClassHTTP      = "http"
+	ClassContainer = "container"
+)
+
+// SettingRoutes is the setting with the comma-separated route templates of
+// the app, e.g. "metrics-refinery/routes=/users/:id,/static/*" (as an
+// annotation, since label values can't contain "/").
+const SettingRoutes = "routes"
+
+// parseSettings extracts the labels and annotations prefixed by SettingsPrefix,
+// with the prefix removed. Annotations take precedence over labels.
+func parseSettings(md v3Metadata) map[string]string {
+	var settings map[string]string
+	for _, kvs := range []map[string]string{md.Labels, md.Annotations} {
+		for k, v := range kvs {
+			if !strings.HasPrefix(k, SettingsPrefix) {
+				continue
+			}
+			if settings == nil {
+				settings = make(map[string]string)
+			}
+			settings[strings.TrimPrefix(k, SettingsPrefix)] = v
+		}
+	}
+	return settings
+}
+
+// SampleRate returns the fraction of events of the specified class that
+// should be kept for the app, according to its settings: "<class>=off"
+// disables the class completely, "<class>-sample=<rate>" keeps only the
+// specified fraction of events. Invalid settings are ignored.
+func (md AppMetadata) SampleRate(class string) float64 {
+	switch strings.ToLower(md.Settings[class]) {
+	case "off", "false", "disabled":
+		return 0
+	}
+	if v, found := md.Settings[class+"-sample"]; found {
+		if rate, err := strconv.ParseFloat(v, 64); err == nil && rate >= 0 && rate <= 1 {
+			return rate
+		}
+	}
+	return 1
+}
@@ -0,0 +1,31 @@
+package enricher
+
+import "testing"
+
+func TestAppMetadata_SampleRate(t *testing.T) {
+	md := AppMetadata{Settings: map[string]string{
+		"logs":             "off",
+		"http-sample":      "0.1",
+		"container":        "on",
+		"container-sample": "2",
+	}}
+
+	tests := []struct {
+		class string
+		want  float64
+	}{
+		{ClassLogs, 0},
+		{ClassHTTP, 0.1},
+		{ClassContainer, 1}, // invalid rate is ignored
+		{"unknown", 1},
+	}
+	for _, tt := range tests {
+		if got := md.SampleRate(tt.class); got != tt.want {
+			t.Fatalf("AppMetadata.SampleRate(%q) = %v, want %v", tt.class, got, tt.want)
+		}
+	}
+
+	if got := (AppMetadata{}).SampleRate(ClassLogs); got != 1 {
+		t.Fatalf("AppMetadata.SampleRate() without settings = %v, want 1", got)
+	}
+}
@@ -0,0 +1,76 @@
+package filter
+
+import (
+	"math/rand"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+// Filter decides which enriched envelopes are forwarded to the output.
+type Filter interface {
+	// Keep returns false if the envelope must be dropped. Filters that
+	// sample envelopes record the sampling rate in the envelope.
+	Keep(*transformer.Envelope) bool
+}
+
+// Chain is a Filter that keeps an envelope only if all its Filters keep it.
+type Chain []Filter
+
+func (c Chain) Keep(e *transformer.Envelope) bool {
+	for _, f := range c {
+		if !f.Keep(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// AppSettings is a Filter that honors the per-app sampling settings found in
+// the app metadata (see enricher.AppMetadata.SampleRate).
+type AppSettings struct {
+	random func() float64
+}
+
+func NewAppSettings() *AppSettings {
+	return &AppSettings{random: rand.Float64}
+}
+
+func (f *AppSettings) Keep(e *transformer.Envelope) bool {
+	class := eventClass(e.Event)
+	if class == "" {
+		return true
+	}
+	return sample(e, e.Meta.SampleRate(class), f.random)
+}
+
+// eventClass returns the enricher event class of the event, or "" if the
+// event does not belong to any class.
+func eventClass(e *events.Envelope) string {
+	switch e.GetEventType() {
+	case events.Envelope_LogMessage:
+		return enricher.ClassLogs
+	case events.Envelope_HttpStartStop:
+		return enricher.ClassHTTP
+	case events.Envelope_ContainerMetric:
+		return enricher.ClassContainer
+	}
+	return ""
+}
+
+// sample keeps the envelope with the specified probability, recording the
+// sample rate in the envelope (multiplied by the rate of previous samplings).
+func sample(e *transformer.Envelope, rate float64, random func() float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 || random() >= rate {
+		return false
+	}
+	if e.SampleRate > 0 {
+		rate *= e.SampleRate
+	}
+	e.SampleRate = rate
+	return true
+}
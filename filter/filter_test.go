@@ -0,0 +1,118 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+const logMsg = `{
+	"origin": "rep",
+	"eventType": 5,
+	"timestamp": 123456789012345678,
+	"deployment": "cf",
+	"job": "cell",
+	"index": "0",
+	"ip": "192.168.0.50",
+	"logMessage": {
+		"message": "aGVsbG8gd29ybGQK",
+		"message_type": 2,
+		"timestamp": 123456789012345000,
+		"app_id": "00000000-0000-0000-0000-000000000000",
+		"source_type": "APP/PROC/WEB",
+		"source_instance": "1"
+	}
+}`
+
+const httpStartStop = `{
+	"origin": "gorouter",
+	"eventType": 4,
+	"timestamp": 123456789012345678,
+	"job": "router",
+	"index": "1",
+	"ip": "192.168.0.50",
+	"HttpStartStop": {
+		"startTimestamp": 1524923912949154418,
+		"stopTimestamp": 1524923912949154418,
+		"peerType": 1,
+		"method": 3,
+		"statusCode": 200,
+		"applicationId": {"low": 14285923797169022654, "high": 6940295952872734603},
+		"instanceIndex": 1
+	}
+}`
+
+func envelope(t *testing.T, msg string, meta enricher.AppMetadata) *transformer.Envelope {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(msg), &e); err != nil {
+		t.Fatal(err)
+	}
+	return &transformer.Envelope{Event: &e, Meta: meta}
+}
+
+func fixedRandom(v float64) func() float64 {
+	return func() float64 { return v }
+}
+
+func TestAppSettings(t *testing.T) {
+	settings := map[string]string{"logs": "off", "http-sample": "0.1"}
+
+	tests := []struct {
+		name           string
+		msg            string
+		settings       map[string]string
+		random         float64
+		want           bool
+		wantSampleRate float64
+	}{
+		{"no settings", logMsg, nil, 0.5, true, 0},
+		{"logs off", logMsg, settings, 0, false, 0},
+		{"http sampled in", httpStartStop, settings, 0.05, true, 0.1},
+		{"http sampled out", httpStartStop, settings, 0.1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := envelope(t, tt.msg, enricher.AppMetadata{App: "app", Settings: tt.settings})
+			f := &AppSettings{random: fixedRandom(tt.random)}
+			if got := f.Keep(e); got != tt.want {
+				t.Fatalf("AppSettings.Keep() = %v, want %v", got, tt.want)
+			}
+			if e.SampleRate != tt.wantSampleRate {
+				t.Fatalf("AppSettings.Keep() sample rate = %v, want %v", e.SampleRate, tt.wantSampleRate)
+			}
+		})
+	}
+}
+
+type constFilter bool
+
+func (f constFilter) Keep(_ *transformer.Envelope) bool {
+	return bool(f)
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain Chain
+		want  bool
+	}{
+		{"empty", Chain{}, true},
+		{"all keep", Chain{constFilter(true), constFilter(true)}, true},
+		{"one drops", Chain{constFilter(true), constFilter(false)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.chain.Keep(&transformer.Envelope{}); got != tt.want {
+			t.Fatalf("%s: Chain.Keep() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSample_combined(t *testing.T) {
+	e := &transformer.Envelope{SampleRate: 0.5}
+	if !sample(e, 0.1, fixedRandom(0)) || e.SampleRate != 0.05 {
+		t.Fatalf("sample() sample rate = %v, want 0.05", e.SampleRate)
+	}
+}
@@ -0,0 +1,148 @@
+package filter
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pkg/errors"
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+type ConfigFilter struct {
+	RulesFile string `desc:"Path of the JSON file with the filtering and sampling rules"` // CFMR_FILTER_RULESFILE
+}
+
+// Rule actions
+const (
+	ActionDrop   = "drop"
+	ActionKeep   = "keep"
+	ActionSample = "sample"
+)
+
+// Rule matches enriched envelopes and decides whether they are kept, e.g.
+//
+//	{"name": "sandbox", "org": "sandbox-*", "action": "drop"}
+//	{"name": "busy-apps", "app": "shop-*", "event_type": "HttpStartStop", "action": "sample", "rate": 0.1}
+//	{"name": "noisy-stderr", "org": "system", "space": "noisy", "event_type": "LogMessage", "message_type": "ERR", "action": "drop"}
+//
+// Org, Space, App and SourceType are globs, where * matches any string
+// and ? any character; empty fields match any envelope.
+type Rule struct {
+	Name        string  `json:"name"`
+	Org         string  `json:"org"`
+	Space       string  `json:"space"`
+	App         string  `json:"app"`
+	EventType   string  `json:"event_type"`   // e.g. HttpStartStop
+	SourceType  string  `json:"source_type"`  // e.g. APP/*; only log messages have a source type
+	MessageType string  `json:"message_type"` // OUT or ERR; only log messages have a message type
+	Action      string  `json:"action"`       // drop, keep or sample
+	Rate        float64 `json:"rate"`         // fraction of the envelopes kept by sample
+
+	org, space, app, sourceType *regexp.Regexp
+}
+
+func (r *Rule) compile() error {
+	r.org, r.space, r.app, r.sourceType = glob(r.Org), glob(r.Space), glob(r.App), glob(r.SourceType)
+	if _, ok := events.Envelope_EventType_value[r.EventType]; r.EventType != "" && !ok {
+		return errors.Errorf("unknown event type %q", r.EventType)
+	}
+	if _, ok := events.LogMessage_MessageType_value[r.MessageType]; r.MessageType != "" && !ok {
+		return errors.Errorf("unknown message type %q", r.MessageType)
+	}
+	switch r.Action {
+	case ActionDrop, ActionKeep:
+	case ActionSample:
+		if r.Rate <= 0 || r.Rate > 1 {
+			return errors.Errorf("invalid sample rate %v", r.Rate)
+		}
+	default:
+		return errors.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+func (r *Rule) matches(e *transformer.Envelope) bool {
+	if !match(r.org, e.Meta.Org) || !match(r.space, e.Meta.Space) || !match(r.app, e.Meta.App) {
+		return false
+	}
+	if r.EventType != "" && r.EventType != e.Event.GetEventType().String() {
+		return false
+	}
+	if r.SourceType == "" && r.MessageType == "" {
+		return true
+	}
+	m := e.Event.GetLogMessage()
+	if m == nil {
+		return false
+	}
+	return match(r.sourceType, m.GetSourceType()) &&
+		(r.MessageType == "" || r.MessageType == m.GetMessageType().String())
+}
+
+// glob compiles a glob into a regular expression, or nil if it is empty.
+func glob(g string) *regexp.Regexp {
+	if g == "" {
+		return nil
+	}
+	re := regexp.QuoteMeta(g)
+	re = strings.Replace(re, `\*`, ".*", -1)
+	re = strings.Replace(re, `\?`, ".", -1)
+	return regexp.MustCompile("^" + re + "$")
+}
+
+func match(re *regexp.Regexp, s string) bool {
+	return re == nil || re.MatchString(s)
+}
+
+// Rules is a Filter applying the action of the first Rule matching the
+// envelope; envelopes not matching any rule are kept.
+type Rules struct {
+	rules  []Rule
+	random func() float64
+}
+
+// NewRules validates the rules.
+func NewRules(rules []Rule) (*Rules, error) {
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, errors.Wrapf(err, "compiling filter rule %d (%s)", i, rules[i].Name)
+		}
+	}
+	return &Rules{rules: rules, random: rand.Float64}, nil
+}
+
+// LoadRules loads the rules from a JSON file containing a list of Rules.
+func LoadRules(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading filter rules")
+	}
+	defer f.Close()
+
+	var rules []Rule
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, errors.Wrapf(err, "parsing filter rules %s", path)
+	}
+	return NewRules(rules)
+}
+
+func (f *Rules) Keep(e *transformer.Envelope) bool {
+	for i := range f.rules {
+		r := &f.rules[i]
+		if !r.matches(e) {
+			continue
+		}
+		switch r.Action {
+		case ActionDrop:
+			return false
+		case ActionSample:
+			return sample(e, r.Rate, f.random)
+		}
+		return true
+	}
+	return true
+}
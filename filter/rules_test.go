@@ -0,0 +1,85 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := NewRules([]Rule{
+		{Name: "sandbox", Org: "sandbox-*", Action: ActionDrop},
+		{Name: "important", App: "shop", EventType: "HttpStartStop", Action: ActionKeep},
+		{Name: "busy", App: "shop*", EventType: "HttpStartStop", Action: ActionSample, Rate: 0.1},
+		{Name: "noisy", Org: "system", Space: "noisy", SourceType: "APP/*", MessageType: "ERR", Action: ActionDrop},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := func(org, space, app string) enricher.AppMetadata {
+		return enricher.AppMetadata{Org: org, Space: space, App: app}
+	}
+	tests := []struct {
+		name           string
+		msg            string
+		meta           enricher.AppMetadata
+		random         float64
+		want           bool
+		wantSampleRate float64
+	}{
+		{"no match", logMsg, meta("org", "space", "app"), 0.5, true, 0},
+		{"sandbox org", logMsg, meta("sandbox-alice", "space", "app"), 0.5, false, 0},
+		{"kept before sampling", httpStartStop, meta("org", "space", "shop"), 0.5, true, 0},
+		{"sampled in", httpStartStop, meta("org", "space", "shop-eu"), 0.05, true, 0.1},
+		{"sampled out", httpStartStop, meta("org", "space", "shop-eu"), 0.5, false, 0},
+		{"logs not sampled", logMsg, meta("org", "space", "shop-eu"), 0.5, true, 0},
+		{"stderr of noisy space", logMsg, meta("system", "noisy", "app"), 0.5, false, 0},
+		{"http of noisy space", httpStartStop, meta("system", "noisy", "app"), 0.5, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := envelope(t, tt.msg, tt.meta)
+			rules.random = fixedRandom(tt.random)
+			if got := rules.Keep(e); got != tt.want {
+				t.Fatalf("Rules.Keep() = %v, want %v", got, tt.want)
+			}
+			if e.SampleRate != tt.wantSampleRate {
+				t.Fatalf("Rules.Keep() sample rate = %v, want %v", e.SampleRate, tt.wantSampleRate)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", `[{"org": "sandbox-*", "action": "drop"}, {"app": "shop", "action": "sample", "rate": 0.1}]`, false},
+		{"unknown action", `[{"org": "sandbox-*", "action": "delete"}]`, true},
+		{"invalid rate", `[{"app": "shop", "action": "sample", "rate": 2}]`, true},
+		{"unknown event type", `[{"event_type": "HttpRequest", "action": "drop"}]`, true},
+		{"unknown message type", `[{"message_type": "STDERR", "action": "drop"}]`, true},
+		{"invalid JSON", `{"org": "sandbox-*"}`, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "rules.json")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRules(path); (err != nil) != tt.wantErr {
+			t.Fatalf("LoadRules() %s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
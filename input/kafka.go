@@ -88,6 +88,7 @@ func (c *KafkaConsumer) Process(message *sarama.ConsumerMessage) (*transformer.E
 	}
 
 	t[message.Partition] = message.Offset
+	envelope.Source = message.Topic
 	envelope.Input = message
 
 	return envelope, nil
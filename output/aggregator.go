@@ -0,0 +1,290 @@
+package output
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+type ConfigAggregator struct {
+	Measurements []string      `desc:"Measurements whose points are aggregated into rollups (e.g. http_request,log)"`                                    // CFMR_AGGREGATOR_MEASUREMENTS
+	Window       time.Duration `default:"10s" desc:"Size of the aggregation windows"`                                                                    // CFMR_AGGREGATOR_WINDOW
+	Watermark    time.Duration `default:"30s" desc:"How late events can arrive before their window is closed"`                                           // CFMR_AGGREGATOR_WATERMARK
+	Fields       []string      `default:"duration,response_time,size,response_size" desc:"Fields summarized in the rollups with sum, min, max and mean"` // CFMR_AGGREGATOR_FIELDS
+
+	SketchFields    []string  `default:"duration,response_time" desc:"Fields whose percentiles are computed in the rollups"`           // CFMR_AGGREGATOR_SKETCHFIELDS
+	Percentiles     []float64 `default:"50,95,99" desc:"Percentiles written for the sketch fields (e.g. duration_p99)"`                // CFMR_AGGREGATOR_PERCENTILES
+	SketchAccuracy  float64   `default:"0.01" desc:"Relative accuracy of the percentiles"`                                             // CFMR_AGGREGATOR_SKETCHACCURACY
+	SketchSerialize bool      `default:"false" desc:"Write the sketches (e.g. duration_sketch) so that they can be merged downstream"` // CFMR_AGGREGATOR_SKETCHSERIALIZE
+}
+
+// AggregatorCallback is called for every point aggregated, or written as is
+// because it arrived after its window was closed.
+type AggregatorCallback func(late bool)
+
+// Aggregator aggregates the points of the configured measurements into one
+// rollup point per series and window before passing them to the parent.
+//
+// Windows are closed when the watermark (the latest event time seen, but
+// not after the current time, minus ConfigAggregator.Watermark) passes
+// their end, or, if no points were aggregated for the window size plus the
+// watermark delay (e.g. the input is idle), at the next WriteAsync, even
+// without envelopes. The envelopes are passed on immediately, without the
+// aggregated points, so that their input is committed: the open windows are
+// lost if the process crashes.
+type Aggregator struct { // AsyncWriter
+	parent AsyncWriter
+
+	cfg          ConfigAggregator
+	measurements map[string]bool
+	cb           AggregatorCallback
+	now          func() time.Time
+
+	l         sync.Mutex
+	watermark time.Time
+	lastAdd   time.Time                    // when the last point was aggregated
+	windows   map[int64]map[string]*rollup // window start -> series -> rollup
+}
+
+// rollup aggregates the points of a series in a window.
+type rollup struct {
+	name      string
+	tags      map[string]string
+	kind      transformer.Kind
+	count     int64
+	estimated float64 // count corrected by the sample rate
+	sampled   bool
+	fields    map[string]*summary
+	sketches  map[string]*Sketch
+}
+
+type summary struct {
+	sum, min, max float64
+	n             int64
+}
+
+func NewAggregator(parent AsyncWriter, cfg ConfigAggregator, cb AggregatorCallback) (*Aggregator, error) {
+	if cfg.Window <= 0 {
+		return nil, errors.New("the aggregation window must be positive")
+	}
+	if len(cfg.SketchFields) > 0 {
+		if _, err := NewSketch(cfg.SketchAccuracy); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range cfg.Percentiles {
+		if p < 0 || p > 100 {
+			return nil, errors.Errorf("invalid percentile %v", p)
+		}
+	}
+	measurements := make(map[string]bool, len(cfg.Measurements))
+	for _, m := range cfg.Measurements {
+		measurements[m] = true
+	}
+	return &Aggregator{
+		parent:       parent,
+		cfg:          cfg,
+		measurements: measurements,
+		cb:           cb,
+		now:          time.Now,
+		windows:      make(map[int64]map[string]*rollup),
+	}, nil
+}
+
+// WriteAsync aggregates the points of the envelopes and passes the envelopes,
+// followed by the rollups of the windows closed, to the parent.
+func (a *Aggregator) WriteAsync(envs ...*transformer.Envelope) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	for _, e := range envs {
+		ps := e.Points()
+		kept := make([]*transformer.Point, 0, len(ps))
+		for _, p := range ps {
+			if !a.measurements[p.Name] {
+				kept = append(kept, p)
+				continue
+			}
+			if !a.add(p) {
+				kept = append(kept, p)
+			}
+		}
+		e.Output = kept
+	}
+
+	if a.now().Sub(a.lastAdd) >= a.cfg.Window+a.cfg.Watermark {
+		// the watermark does not advance without points: close the open
+		// windows, as their points should have arrived by now
+		for start := range a.windows {
+			if end := time.Unix(0, start).Add(a.cfg.Window); end.After(a.watermark) {
+				a.watermark = end
+			}
+		}
+	}
+	closed := a.closeWithLock(a.watermark)
+	return a.parent.WriteAsync(append(envs[:len(envs):len(envs)], closed...)...)
+}
+
+// Flush closes all windows and flushes the parent.
+func (a *Aggregator) Flush() error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if closed := a.closeWithLock(time.Time{}); len(closed) > 0 {
+		if err := a.parent.WriteAsync(closed...); err != nil {
+			return errors.Wrap(err, "writing rollups")
+		}
+	}
+	return a.parent.Flush()
+}
+
+// add adds the point to its window; it returns false if the window was
+// already closed.
+func (a *Aggregator) add(p *transformer.Point) bool {
+	start := p.Time.Truncate(a.cfg.Window)
+	if !start.Add(a.cfg.Window).After(a.watermark) {
+		if a.cb != nil {
+			a.cb(true)
+		}
+		return false
+	}
+
+	w, ok := a.windows[start.UnixNano()]
+	if !ok {
+		w = make(map[string]*rollup)
+		a.windows[start.UnixNano()] = w
+	}
+	series := p.Series()
+	r, ok := w[series]
+	if !ok {
+		r = &rollup{name: p.Name, tags: p.Tags, kind: p.Kind, fields: make(map[string]*summary), sketches: make(map[string]*Sketch)}
+		w[series] = r
+	}
+	r.add(p, &a.cfg)
+
+	now := a.now()
+	a.lastAdd = now
+	latest := p.Time
+	if latest.After(now) {
+		latest = now
+	}
+	if wm := latest.Add(-a.cfg.Watermark); wm.After(a.watermark) {
+		a.watermark = wm
+	}
+
+	if a.cb != nil {
+		a.cb(false)
+	}
+	return true
+}
+
+// closeWithLock closes the windows ending before the watermark (all of them
+// if it is zero) and returns one envelope with the rollups of each.
+func (a *Aggregator) closeWithLock(watermark time.Time) []*transformer.Envelope {
+	var starts []int64
+	for start := range a.windows {
+		end := time.Unix(0, start).Add(a.cfg.Window)
+		if watermark.IsZero() || !end.After(watermark) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	envs := make([]*transformer.Envelope, 0, len(starts))
+	for _, start := range starts {
+		w := a.windows[start]
+		delete(a.windows, start)
+
+		series := make([]string, 0, len(w))
+		for s := range w {
+			series = append(series, s)
+		}
+		sort.Strings(series)
+
+		ps := make([]*transformer.Point, 0, len(w))
+		for _, s := range series {
+			ps = append(ps, w[s].point(time.Unix(0, start), &a.cfg))
+		}
+		envs = append(envs, &transformer.Envelope{Output: ps})
+	}
+	return envs
+}
+
+func (r *rollup) add(p *transformer.Point, cfg *ConfigAggregator) {
+	r.count++
+	if rate, ok := p.Fields["sample_rate"].(float64); ok && rate > 0 {
+		r.estimated += 1 / rate
+		r.sampled = true
+	} else {
+		r.estimated++
+	}
+
+	for _, f := range cfg.Fields {
+		v, ok := numericField(p, f)
+		if !ok {
+			continue
+		}
+		s, ok := r.fields[f]
+		if !ok {
+			s = &summary{min: math.Inf(1), max: math.Inf(-1)}
+			r.fields[f] = s
+		}
+		s.sum += v
+		s.min = math.Min(s.min, v)
+		s.max = math.Max(s.max, v)
+		s.n++
+	}
+
+	for _, f := range cfg.SketchFields {
+		v, ok := numericField(p, f)
+		if !ok {
+			continue
+		}
+		sk, ok := r.sketches[f]
+		if !ok {
+			sk, _ = NewSketch(cfg.SketchAccuracy) // validated by NewAggregator
+			r.sketches[f] = sk
+		}
+		sk.Add(v)
+	}
+}
+
+func numericField(p *transformer.Point, f string) (float64, bool) {
+	switch v := p.Fields[f].(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// point returns the rollup point: count, the estimated_count if some points
+// were sampled, the sum, min, max and mean of the summarized fields, and the
+// percentiles (and optionally the sketch) of the sketch fields.
+func (r *rollup) point(t time.Time, cfg *ConfigAggregator) *transformer.Point {
+	fields := map[string]interface{}{"count": r.count}
+	if r.sampled {
+		fields["estimated_count"] = r.estimated
+	}
+	for f, s := range r.fields {
+		fields[f+"_sum"] = s.sum
+		fields[f+"_min"] = s.min
+		fields[f+"_max"] = s.max
+		fields[f+"_mean"] = s.sum / float64(s.n)
+	}
+	for f, sk := range r.sketches {
+		for _, p := range cfg.Percentiles {
+			fields[f+"_p"+strconv.FormatFloat(p, 'f', -1, 64)] = sk.Quantile(p / 100)
+		}
+		if cfg.SketchSerialize {
+			fields[f+"_sketch"] = sk.String()
+		}
+	}
+	return &transformer.Point{Name: r.name, Tags: r.tags, Fields: fields, Time: t, Kind: r.kind}
+}
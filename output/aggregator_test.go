@@ -0,0 +1,249 @@
+package output
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+type recordAsyncWriter struct {
+	rec     []*transformer.Envelope
+	flushed int
+}
+
+func (w *recordAsyncWriter) WriteAsync(e ...*transformer.Envelope) error {
+	w.rec = append(w.rec, e...)
+	return nil
+}
+
+func (w *recordAsyncWriter) Flush() error {
+	w.flushed++
+	return nil
+}
+
+func (w *recordAsyncWriter) points() []*transformer.Point {
+	var ps []*transformer.Point
+	for _, e := range w.rec {
+		ps = append(ps, e.Points()...)
+	}
+	return ps
+}
+
+func httpEnvelope(t time.Time, instance string, duration float64) *transformer.Envelope {
+	return &transformer.Envelope{Input: t, Output: []*transformer.Point{{
+		Name:   "http_request",
+		Tags:   map[string]string{"app": "app", "instance": instance},
+		Fields: map[string]interface{}{"count": int64(1), "duration": duration, "response_size": int64(100)},
+		Time:   t,
+		Kind:   transformer.KindEvent,
+	}}}
+}
+
+func TestAggregator(t *testing.T) {
+	base := time.Unix(1500000000, 0)
+	w := &recordAsyncWriter{}
+	var aggregated, late int
+	a, err := NewAggregator(w, ConfigAggregator{
+		Measurements: []string{"http_request"},
+		Window:       10 * time.Second,
+		Watermark:    5 * time.Second,
+		Fields:       []string{"duration"},
+	}, func(l bool) {
+		if l {
+			late++
+		} else {
+			aggregated++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return base.Add(time.Hour) }
+
+	other := &transformer.Envelope{Output: []*transformer.Point{{Name: "instance", Fields: map[string]interface{}{"cpu": 0.5}, Time: base}}}
+	envs := []*transformer.Envelope{
+		httpEnvelope(base.Add(1*time.Second), "0", 0.1),
+		httpEnvelope(base.Add(2*time.Second), "0", 0.3),
+		httpEnvelope(base.Add(3*time.Second), "1", 0.2),
+		other,
+	}
+	if err := a.WriteAsync(envs...); err != nil {
+		t.Fatal(err)
+	}
+	// the envelopes are passed on without the aggregated points
+	if len(w.rec) != 4 || len(w.points()) != 1 || w.points()[0].Name != "instance" {
+		t.Fatalf("expected the envelopes with only the other points, got %v", w.points())
+	}
+
+	// the watermark passes the end of the first window
+	if err := a.WriteAsync(httpEnvelope(base.Add(16*time.Second), "0", 1)); err != nil {
+		t.Fatal(err)
+	}
+	want := []*transformer.Point{
+		{Name: "instance", Fields: map[string]interface{}{"cpu": 0.5}, Time: base},
+		{
+			Name:   "http_request",
+			Tags:   map[string]string{"app": "app", "instance": "0"},
+			Fields: map[string]interface{}{"count": int64(2), "duration_sum": 0.4, "duration_min": 0.1, "duration_max": 0.3, "duration_mean": 0.2},
+			Time:   base,
+			Kind:   transformer.KindEvent,
+		},
+		{
+			Name:   "http_request",
+			Tags:   map[string]string{"app": "app", "instance": "1"},
+			Fields: map[string]interface{}{"count": int64(1), "duration_sum": 0.2, "duration_min": 0.2, "duration_max": 0.2, "duration_mean": 0.2},
+			Time:   base,
+			Kind:   transformer.KindEvent,
+		},
+	}
+	if got := w.points(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	// late events are written as they are
+	lateEnv := httpEnvelope(base.Add(4*time.Second), "0", 0.5)
+	if err := a.WriteAsync(lateEnv); err != nil {
+		t.Fatal(err)
+	}
+	if ps := lateEnv.Points(); len(ps) != 1 || ps[0].Fields["duration"] != 0.5 {
+		t.Fatalf("expected the late point to be written as is, got %v", ps)
+	}
+	if aggregated != 4 || late != 1 {
+		t.Fatalf("expected 4 aggregated and 1 late points, got %d and %d", aggregated, late)
+	}
+
+	// the open window is written on flush
+	w.rec = nil
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 1 || ps[0].Time != base.Add(10*time.Second) || ps[0].Fields["count"] != int64(1) {
+		t.Fatalf("expected the rollup of the open window, got %v", ps)
+	}
+	if w.flushed != 1 {
+		t.Fatal("expected the parent to be flushed")
+	}
+}
+
+func TestAggregator_sampled(t *testing.T) {
+	base := time.Unix(1500000000, 0)
+	w := &recordAsyncWriter{}
+	a, err := NewAggregator(w, ConfigAggregator{Measurements: []string{"http_request"}, Window: time.Minute}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		e := httpEnvelope(base, "0", 0.1)
+		e.Points()[0].Fields["sample_rate"] = 0.25
+		if err := a.WriteAsync(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ps := w.points()
+	if len(ps) != 1 || ps[0].Fields["count"] != int64(2) || ps[0].Fields["estimated_count"] != 8.0 {
+		t.Fatalf("expected count 2 and estimated count 8, got %v", ps)
+	}
+}
+
+func TestAggregator_percentiles(t *testing.T) {
+	base := time.Unix(1500000000, 0)
+	w := &recordAsyncWriter{}
+	a, err := NewAggregator(w, ConfigAggregator{
+		Measurements:    []string{"http_request"},
+		Window:          time.Minute,
+		SketchFields:    []string{"duration"},
+		Percentiles:     []float64{50, 99.9},
+		SketchAccuracy:  0.01,
+		SketchSerialize: true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 100; i++ {
+		if err := a.WriteAsync(httpEnvelope(base, "0", float64(i)/100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ps := w.points()
+	if len(ps) != 1 {
+		t.Fatalf("expected one rollup, got %v", ps)
+	}
+	f := ps[0].Fields
+	if p50 := f["duration_p50"].(float64); math.Abs(p50-0.5)/0.5 > 0.01 {
+		t.Fatalf("expected p50 0.5, got %v", p50)
+	}
+	if p999 := f["duration_p99.9"].(float64); math.Abs(p999-1)/1 > 0.01 {
+		t.Fatalf("expected p99.9 1, got %v", p999)
+	}
+	sk, err := ParseSketch(f["duration_sketch"].(string))
+	if err != nil || sk.Count() != 100 {
+		t.Fatalf("expected a sketch of 100 values, got %v (%v)", sk, err)
+	}
+}
+
+func TestNewAggregator(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ConfigAggregator
+	}{
+		{"no window", ConfigAggregator{}},
+		{"invalid accuracy", ConfigAggregator{Window: time.Second, SketchFields: []string{"duration"}, SketchAccuracy: 1}},
+		{"invalid percentile", ConfigAggregator{Window: time.Second, Percentiles: []float64{101}}},
+	}
+	for _, test := range tests {
+		if _, err := NewAggregator(&recordAsyncWriter{}, test.cfg, nil); err == nil {
+			t.Fatalf("TestNewAggregator %s: expected an error", test.name)
+		}
+	}
+}
+
+func TestAggregator_idle(t *testing.T) {
+	base := time.Unix(1500000000, 0)
+	w := &recordAsyncWriter{}
+	a, err := NewAggregator(w, ConfigAggregator{Measurements: []string{"http_request"}, Window: 10 * time.Second, Watermark: 5 * time.Second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := base.Add(time.Second)
+	a.now = func() time.Time { return now }
+
+	if err := a.WriteAsync(httpEnvelope(base, "0", 0.1)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(14 * time.Second)
+	if err := a.WriteAsync(); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 0 {
+		t.Fatalf("expected the window to be open, got %v", ps)
+	}
+
+	// no points for the window size plus the watermark delay
+	now = now.Add(time.Second)
+	if err := a.WriteAsync(); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 1 || ps[0].Time != base || ps[0].Fields["count"] != int64(1) {
+		t.Fatalf("expected the rollup of the idle window, got %v", ps)
+	}
+
+	// the window is closed: its points are late
+	lateEnv := httpEnvelope(base.Add(time.Second), "0", 0.5)
+	if err := a.WriteAsync(lateEnv); err != nil {
+		t.Fatal(err)
+	}
+	if ps := lateEnv.Points(); len(ps) != 1 || ps[0].Fields["duration"] != 0.5 {
+		t.Fatalf("expected the late point to be written as is, got %v", ps)
+	}
+}
@@ -32,7 +32,8 @@ func (b *Batcher) WriteAsync(envs ...*transformer.Envelope) error {
 	b.l.Lock()
 	defer b.l.Unlock()
 
-	// FIXME: make time-based flushing independent of WriteAsync calls
+	// the time-based flush also needs WriteAsync calls: the main loop calls
+	// it without envelopes when idle
 	if len(b.envelopes) == 0 {
 		b.firstWrite = time.Now()
 	}
@@ -52,6 +52,30 @@ func TestBatcher(t *testing.T) {
 	}
 }
 
+func TestBatcherTick(t *testing.T) {
+	w := &recordWriter{}
+	b := NewBatcher(w, ConfigBatcher{50 * time.Millisecond, 1000})
+
+	if err := b.WriteAsync(&transformer.Envelope{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := b.WriteAsync(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(w.rec) != 0 {
+		t.Fatal("unexpected write")
+	}
+
+	// an empty write after the flush interval writes the pending envelopes
+	time.Sleep(50 * time.Millisecond)
+	if err := b.WriteAsync(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(w.rec) != 1 || len(w.rec[0]) != 1 {
+		t.Fatalf("expected the pending envelope to be written, got %v", w.rec)
+	}
+}
+
 func TestBatcherFlush(t *testing.T) {
 	w := &recordWriter{}
 	b := NewBatcher(w, ConfigBatcher{1000 * time.Millisecond, 1000})
@@ -0,0 +1,183 @@
+package output
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+type ConfigCorrelator struct {
+	Timeout    time.Duration `default:"5s" desc:"How long to wait for the other HttpStartStop event of a request before writing an event alone"` // CFMR_CORRELATOR_TIMEOUT
+	MaxPending int           `default:"100000" desc:"Maximum number of HttpStartStop events waiting for the other event of their request"`       // CFMR_CORRELATOR_MAXPENDING
+}
+
+// CorrelatorCallback is called for every pair of events merged, or event
+// written alone because the other event of its request did not arrive in
+// time.
+type CorrelatorCallback func(merged bool)
+
+// Correlator merges the http_request points of the gorouter and of the app
+// side for the same request (identified by transformer.RequestIDField) into
+// a single point, with the tags and fields of the gorouter point plus the
+// app_duration field.
+//
+// Points are held until the other point of their request arrives, for at
+// most ConfigCorrelator.Timeout. As with the Aggregator, the envelopes are
+// passed on immediately without the held points, so that their input is
+// committed: the held points are lost if the process crashes.
+type Correlator struct { // AsyncWriter
+	parent AsyncWriter
+
+	cfg ConfigCorrelator
+	cb  CorrelatorCallback
+	now func() time.Time
+
+	l       sync.Mutex
+	pending map[string]*heldPoint // request ID -> point
+	queue   []*heldPoint          // in arrival order, including the merged ones
+}
+
+type heldPoint struct {
+	id     string
+	p      *transformer.Point
+	at     time.Time
+	merged bool
+}
+
+func NewCorrelator(parent AsyncWriter, cfg ConfigCorrelator, cb CorrelatorCallback) (*Correlator, error) {
+	if cfg.Timeout <= 0 {
+		return nil, errors.New("the correlation timeout must be positive")
+	}
+	if cfg.MaxPending <= 0 {
+		return nil, errors.New("the maximum number of pending events must be positive")
+	}
+	return &Correlator{
+		parent:  parent,
+		cfg:     cfg,
+		cb:      cb,
+		now:     time.Now,
+		pending: make(map[string]*heldPoint),
+	}, nil
+}
+
+// WriteAsync merges the points of the envelopes with the held points of the
+// same requests and passes the envelopes, followed by the points that timed
+// out, to the parent.
+func (c *Correlator) WriteAsync(envs ...*transformer.Envelope) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	now := c.now()
+	var alone []*transformer.Point
+	for _, e := range envs {
+		ps := e.Points()
+		kept := make([]*transformer.Point, 0, len(ps))
+		for _, p := range ps {
+			id, ok := p.Fields[transformer.RequestIDField].(string)
+			if !ok {
+				kept = append(kept, p)
+				continue
+			}
+			delete(p.Fields, transformer.RequestIDField)
+
+			h, found := c.pending[id]
+			if !found {
+				c.hold(id, p, now)
+				continue
+			}
+			delete(c.pending, id)
+			h.merged = true
+			if h.p.Tags[transformer.PeerTypeTag] == p.Tags[transformer.PeerTypeTag] {
+				// e.g. a request retried by the gorouter: the first event
+				// can't be merged anymore
+				alone = append(alone, h.p)
+				c.callback(false)
+				c.hold(id, p, now)
+				continue
+			}
+			kept = append(kept, mergeHTTP(h.p, p))
+			c.callback(true)
+		}
+		e.Output = kept
+	}
+
+	alone = append(alone, c.expireWithLock(now.Add(-c.cfg.Timeout))...)
+	if len(alone) > 0 {
+		envs = append(envs[:len(envs):len(envs)], &transformer.Envelope{Output: alone})
+	}
+	return c.parent.WriteAsync(envs...)
+}
+
+// Flush writes all held points and flushes the parent.
+func (c *Correlator) Flush() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if alone := c.expireWithLock(time.Time{}); len(alone) > 0 {
+		if err := c.parent.WriteAsync(&transformer.Envelope{Output: alone}); err != nil {
+			return errors.Wrap(err, "writing uncorrelated points")
+		}
+	}
+	return c.parent.Flush()
+}
+
+func (c *Correlator) hold(id string, p *transformer.Point, now time.Time) {
+	h := &heldPoint{id: id, p: p, at: now}
+	c.pending[id] = h
+	c.queue = append(c.queue, h)
+}
+
+// expireWithLock removes and returns the points held since before the
+// deadline (all of them if it is zero), and the oldest ones over
+// ConfigCorrelator.MaxPending.
+func (c *Correlator) expireWithLock(deadline time.Time) []*transformer.Point {
+	var ps []*transformer.Point
+	i := 0
+	for ; i < len(c.queue); i++ {
+		h := c.queue[i]
+		if h.merged {
+			continue
+		}
+		if !deadline.IsZero() && h.at.After(deadline) && len(c.pending) <= c.cfg.MaxPending {
+			break
+		}
+		delete(c.pending, h.id)
+		ps = append(ps, h.p)
+		c.callback(false)
+	}
+	c.queue = c.queue[i:]
+	return ps
+}
+
+func (c *Correlator) callback(merged bool) {
+	if c.cb != nil {
+		c.cb(merged)
+	}
+}
+
+// mergeHTTP returns the point of the gorouter with the app_duration field
+// taken from the point of the app, and the tags of the app point it lacks.
+func mergeHTTP(a, b *transformer.Point) *transformer.Point {
+	router, app := a, b
+	if b.Tags[transformer.PeerTypeTag] == "client" {
+		router, app = b, a
+	}
+
+	tags := make(map[string]string, len(router.Tags))
+	for k, v := range app.Tags {
+		tags[k] = v
+	}
+	for k, v := range router.Tags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{}, len(router.Fields)+1)
+	for k, v := range router.Fields {
+		fields[k] = v
+	}
+	if d, ok := app.Fields["duration"]; ok {
+		fields["app_duration"] = d
+	}
+	return &transformer.Point{Name: router.Name, Tags: tags, Fields: fields, Time: router.Time, Kind: router.Kind}
+}
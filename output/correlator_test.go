@@ -0,0 +1,117 @@
+package output
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rakutentech/cf-metrics-refinery/transformer"
+)
+
+func requestEnvelope(t time.Time, id, peer string, duration float64) *transformer.Envelope {
+	return &transformer.Envelope{Input: t, Output: []*transformer.Point{{
+		Name:   "http_request",
+		Tags:   map[string]string{"app": "app", transformer.PeerTypeTag: peer},
+		Fields: map[string]interface{}{"count": int64(1), "duration": duration, transformer.RequestIDField: id},
+		Time:   t,
+		Kind:   transformer.KindEvent,
+	}}}
+}
+
+func TestCorrelator(t *testing.T) {
+	base := time.Unix(1500000000, 0)
+	now := base
+	w := &recordAsyncWriter{}
+	var merged, alone int
+	c, err := NewCorrelator(w, ConfigCorrelator{Timeout: 5 * time.Second, MaxPending: 2}, func(m bool) {
+		if m {
+			merged++
+		} else {
+			alone++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+
+	other := &transformer.Envelope{Output: []*transformer.Point{{Name: "instance", Fields: map[string]interface{}{"cpu": 0.5}, Time: base}}}
+	if err := c.WriteAsync(requestEnvelope(base, "a", "client", 0.3), requestEnvelope(base, "b", "server", 0.1), other); err != nil {
+		t.Fatal(err)
+	}
+	// the envelopes are passed on without the held points
+	if len(w.rec) != 3 || len(w.points()) != 1 || w.points()[0].Name != "instance" {
+		t.Fatalf("expected the envelopes with only the other points, got %v", w.points())
+	}
+
+	// the app event of a arrives: the pair is merged
+	w.rec = nil
+	if err := c.WriteAsync(requestEnvelope(base, "a", "server", 0.2)); err != nil {
+		t.Fatal(err)
+	}
+	want := &transformer.Point{
+		Name:   "http_request",
+		Tags:   map[string]string{"app": "app", transformer.PeerTypeTag: "client"},
+		Fields: map[string]interface{}{"count": int64(1), "duration": 0.3, "app_duration": 0.2},
+		Time:   base,
+		Kind:   transformer.KindEvent,
+	}
+	if ps := w.points(); len(ps) != 1 || !reflect.DeepEqual(ps[0], want) {
+		t.Fatalf("expected the merged point %v, got %v", want, ps)
+	}
+	if merged != 1 || alone != 0 {
+		t.Fatalf("unexpected callbacks: %d merged, %d alone", merged, alone)
+	}
+
+	// b times out and is written alone, without the request ID
+	w.rec = nil
+	now = base.Add(6 * time.Second)
+	if err := c.WriteAsync(requestEnvelope(now, "c", "client", 0.4)); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 1 || ps[0].Tags[transformer.PeerTypeTag] != "server" || ps[0].Fields[transformer.RequestIDField] != nil {
+		t.Fatalf("expected b alone, got %v", ps)
+	}
+	if w.rec[len(w.rec)-1].Input != nil {
+		t.Fatalf("expected the timed out points in a new envelope, got %v", w.rec)
+	}
+
+	// the same peer type again: the first event is written alone
+	w.rec = nil
+	if err := c.WriteAsync(requestEnvelope(now, "c", "client", 0.5)); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 1 || ps[0].Fields["duration"] != 0.4 {
+		t.Fatalf("expected the first c alone, got %v", ps)
+	}
+
+	// over MaxPending, the oldest events are written alone
+	w.rec = nil
+	if err := c.WriteAsync(requestEnvelope(now, "d", "client", 0.6), requestEnvelope(now, "e", "client", 0.7)); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 1 || ps[0].Fields["duration"] != 0.5 {
+		t.Fatalf("expected the second c alone, got %v", ps)
+	}
+
+	// Flush writes all held points
+	w.rec = nil
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ps := w.points(); len(ps) != 2 || w.flushed != 1 {
+		t.Fatalf("expected d and e to be flushed, got %v", ps)
+	}
+	if merged != 1 || alone != 5 {
+		t.Fatalf("unexpected callbacks: %d merged, %d alone", merged, alone)
+	}
+}
+
+func TestNewCorrelator(t *testing.T) {
+	if _, err := NewCorrelator(&recordAsyncWriter{}, ConfigCorrelator{MaxPending: 1}, nil); err == nil {
+		t.Fatal("expected an error for a zero timeout")
+	}
+	if _, err := NewCorrelator(&recordAsyncWriter{}, ConfigCorrelator{Timeout: time.Second}, nil); err == nil {
+		t.Fatal("expected an error for a zero MaxPending")
+	}
+}
@@ -9,9 +9,11 @@ import (
 )
 
 type InfluxDB struct { // implements AsyncWriter
-	c   influxdb.Client
-	bpc influxdb.BatchPointsConfig
-	mbe int
+	c          influxdb.Client
+	bpc        influxdb.BatchPointsConfig
+	mbe        int
+	collisions transformer.Collisions
+	tags       transformer.TagPolicy
 }
 
 type ConfigInfluxDB struct {
@@ -21,10 +23,14 @@ type ConfigInfluxDB struct {
 	Addr              string        `required:"true" desc:"URL of InfluxDB"`                                             // CFMR_INFLUXDB_ADDR
 	Timeout           time.Duration `default:"1m" desc:"Timeout for requests to InfluxDB"`                               // CFMR_INFLUXDB_TIMEOUT
 	UserAgent         string        `ignored:"true"`
+	// Collisions avoids overwriting points in the same series and timestamp
+	Collisions transformer.Collisions `ignored:"true"`
 
 	Database          string        `required:"true" desc:"Name of InfluxDB database to write to"`            // CFMR_INFLUXDB_DATABASE
 	RetentionPolicy   string        `desc:"Name of the retention policy to use (instead of the default one)"` // CFMR_INFLUXDB_RETENTIONPOLICY
 	InfluxPingTimeout time.Duration `default:"5s" desc:"Default timeout of checking Influxdb is up or not"`   // CFMR_INFLUXDB_INFLUXPINGTIMEOUT
+
+	Tags transformer.TagPolicy // CFMR_INFLUXDB_TAGS_*
 }
 
 func NewInfluxDB(cfg ConfigInfluxDB) (*InfluxDB, error) {
@@ -45,7 +51,7 @@ func NewInfluxDB(cfg ConfigInfluxDB) (*InfluxDB, error) {
 		RetentionPolicy: cfg.RetentionPolicy,
 	}
 
-	return &InfluxDB{c: c, bpc: bpc}, nil
+	return &InfluxDB{c: c, bpc: bpc, collisions: cfg.Collisions, tags: cfg.Tags}, nil
 }
 
 // Check if the server is up
@@ -54,19 +60,28 @@ func (o *InfluxDB) Ping(timeout time.Duration) error {
 	return err
 }
 
+// Write serializes the points of the envelopes (see transformer.Transform)
+// and writes them to InfluxDB.
 func (o *InfluxDB) Write(envs ...*transformer.Envelope) error {
-	ps := make([]*influxdb.Point, 0, len(envs))
+	points := make([]*transformer.Point, 0, len(envs))
 	for _, e := range envs {
-		p, err := transformer.ToInfluxDBPoint(e)
-		if err == nil {
-			ps = append(ps, p)
-		} else if errors.Cause(err) == transformer.ErrEventDiscarded {
-			continue
-		} else {
-			return errors.Wrap(err, "transforming event to InfluxDB data point")
+		for _, p := range e.Points() {
+			points = append(points, o.tags.Apply(p))
 		}
 	}
 
+	ps := make([]*influxdb.Point, 0, len(points))
+	for _, p := range o.collisions.Resolve(points) {
+		ip, err := influxdb.NewPoint(p.Name, p.Tags, p.Fields, p.Time)
+		if err != nil {
+			return errors.Wrap(err, "serializing InfluxDB data point")
+		}
+		ps = append(ps, ip)
+	}
+	if len(ps) == 0 {
+		return nil
+	}
+
 	b, err := influxdb.NewBatchPoints(o.bpc)
 	if err != nil {
 		return errors.Wrap(err, "creating InfluxDB batch container")
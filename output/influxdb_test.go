@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/cloudfoundry/sonde-go/events"
@@ -51,11 +52,23 @@ func TestInfluxDBLogMessage(t *testing.T) {
 		}
 
 		env := &transformer.Envelope{Meta: test.appMeta, Event: &e}
+		if err := transformer.Transform(env); err != nil && err != transformer.ErrEventDiscarded {
+			t.Fatal(err)
+		}
 
 		if err := o.Write(env); err != nil {
 			t.Fatal(err)
 		}
 
+		if test.res == "" {
+			// batches without points are not written
+			select {
+			case res := <-done:
+				t.Fatalf("unexpected write %q", res)
+			default:
+			}
+			continue
+		}
 		res := <-done
 		if bytes.Compare(res, []byte(test.res)) != 0 {
 			t.Fatalf("expected %q got %q", test.res, res)
@@ -63,6 +76,95 @@ func TestInfluxDBLogMessage(t *testing.T) {
 	}
 }
 
+func TestInfluxDBCollisions(t *testing.T) {
+	done := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		res, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		done <- res
+	}))
+	defer srv.Close()
+
+	o, err := NewInfluxDB(ConfigInfluxDB{
+		Addr:       srv.URL,
+		Database:   "test",
+		Collisions: transformer.Collisions{"log": transformer.CollisionNudge},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a burst of logs of the same instance with the same timestamp
+	var envs []*transformer.Envelope
+	for i := 0; i < 3; i++ {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(AppOutLogMsg), &e); err != nil {
+			t.Fatal(err)
+		}
+		env := &transformer.Envelope{Meta: appMeta, Event: &e}
+		if err := transformer.Transform(env); err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+	if err := o.Write(envs...); err != nil {
+		t.Fatal(err)
+	}
+
+	res := string(<-done)
+	for _, ts := range []string{" 123456789012345000\n", " 123456789012345001\n", " 123456789012345002\n"} {
+		if !strings.Contains(res, ts) {
+			t.Fatalf("expected a point with timestamp%q, got %q", ts, res)
+		}
+	}
+}
+
+func TestInfluxDBTagPolicy(t *testing.T) {
+	done := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		res, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			panic(err)
+		}
+		done <- res
+	}))
+	defer srv.Close()
+
+	o, err := NewInfluxDB(ConfigInfluxDB{
+		Addr:     srv.URL,
+		Database: "test",
+		Tags: transformer.TagPolicy{
+			Drop:   []string{"app_guid", "space_guid", "org_guid"},
+			Rename: map[string]string{"org": "organization"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(AppOutLogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	env := &transformer.Envelope{Meta: appMeta, Event: &e}
+	if err := transformer.Transform(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write(env); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "log,app=app,instance=0,organization=org,space=space,type=OUT count=1i,size=12i 123456789012345000\n"
+	if res := string(<-done); res != want {
+		t.Fatalf("expected %q got %q", want, res)
+	}
+	if env.Points()[0].Tags["org"] != "org" {
+		t.Fatal("the points of the envelope were modified")
+	}
+}
+
 func BenchmarkInfluxDB(b *testing.B) {
 	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
 	defer srv.Close()
@@ -81,6 +183,9 @@ func BenchmarkInfluxDB(b *testing.B) {
 	}
 
 	env := &transformer.Envelope{Meta: appMeta, Event: &e}
+	if err := transformer.Transform(env); err != nil {
+		b.Fatal(err)
+	}
 	envs := []*transformer.Envelope{}
 
 	for i := 0; i < b.N; i++ {
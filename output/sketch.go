@@ -0,0 +1,136 @@
+package output
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// sketchMinValue is the smallest value tracked by the Sketch: smaller
+// (including negative) values are counted as zero.
+const sketchMinValue = 1e-9
+
+// Sketch is a DDSketch: a mergeable quantile sketch whose quantiles have a
+// bounded relative error. Values are counted in buckets whose bounds grow
+// exponentially, so the sketches of different windows or instances with the
+// same accuracy can be merged by adding the counts of their buckets.
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	zero     uint64
+	bins     map[int]uint64
+	count    uint64
+}
+
+// sketchJSON is the serialized form of a Sketch.
+type sketchJSON struct {
+	Gamma float64    `json:"gamma"`
+	Zero  uint64     `json:"zero"`
+	Bins  [][2]int64 `json:"bins"` // [index, count], by index
+}
+
+// NewSketch creates a Sketch whose quantiles have the given relative
+// accuracy (e.g. 0.01 for 1%).
+func NewSketch(accuracy float64) (*Sketch, error) {
+	if accuracy <= 0 || accuracy >= 1 {
+		return nil, errors.Errorf("invalid sketch accuracy %v", accuracy)
+	}
+	return newSketch((1 + accuracy) / (1 - accuracy)), nil
+}
+
+func newSketch(gamma float64) *Sketch {
+	return &Sketch{gamma: gamma, logGamma: math.Log(gamma), bins: make(map[int]uint64)}
+}
+
+// ParseSketch parses a Sketch serialized by String.
+func ParseSketch(s string) (*Sketch, error) {
+	var j sketchJSON
+	if err := json.Unmarshal([]byte(s), &j); err != nil {
+		return nil, errors.Wrap(err, "parsing sketch")
+	}
+	if j.Gamma <= 1 {
+		return nil, errors.Errorf("invalid sketch gamma %v", j.Gamma)
+	}
+	sk := newSketch(j.Gamma)
+	sk.zero = j.Zero
+	sk.count = j.Zero
+	for _, b := range j.Bins {
+		if b[1] < 0 {
+			return nil, errors.Errorf("invalid sketch bin count %d", b[1])
+		}
+		sk.bins[int(b[0])] += uint64(b[1])
+		sk.count += uint64(b[1])
+	}
+	return sk, nil
+}
+
+// Add adds a value to the sketch.
+func (s *Sketch) Add(v float64) {
+	s.count++
+	if v <= sketchMinValue || math.IsNaN(v) {
+		s.zero++
+		return
+	}
+	s.bins[int(math.Ceil(math.Log(v)/s.logGamma))]++
+}
+
+// Count returns the number of values added.
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Merge adds the values of o, which must have the same accuracy.
+func (s *Sketch) Merge(o *Sketch) error {
+	if s.gamma != o.gamma {
+		return errors.Errorf("merging sketches with different accuracy (gamma %v and %v)", s.gamma, o.gamma)
+	}
+	s.zero += o.zero
+	s.count += o.count
+	for i, c := range o.bins {
+		s.bins[i] += c
+	}
+	return nil
+}
+
+// Quantile returns the q-quantile (0 <= q <= 1) of the values, or 0 if the
+// sketch is empty.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := q * float64(s.count-1)
+	if rank < float64(s.zero) {
+		return 0
+	}
+
+	indexes := s.indexes()
+	n := s.zero
+	for _, i := range indexes {
+		n += s.bins[i]
+		if float64(n) > rank {
+			return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+		}
+	}
+	return 2 * math.Pow(s.gamma, float64(indexes[len(indexes)-1])) / (s.gamma + 1)
+}
+
+// String serializes the sketch as JSON (see ParseSketch).
+func (s *Sketch) String() string {
+	j := sketchJSON{Gamma: s.gamma, Zero: s.zero, Bins: make([][2]int64, 0, len(s.bins))}
+	for _, i := range s.indexes() {
+		j.Bins = append(j.Bins, [2]int64{int64(i), int64(s.bins[i])})
+	}
+	b, _ := json.Marshal(j)
+	return string(b)
+}
+
+func (s *Sketch) indexes() []int {
+	indexes := make([]int, 0, len(s.bins))
+	for i := range s.bins {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
@@ -0,0 +1,97 @@
+package output
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	const accuracy = 0.01
+	s, err := NewSketch(accuracy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	for i := 1; i <= 10000; i++ {
+		v := float64(i) / 1000 // 1ms to 10s
+		values = append(values, v)
+		s.Add(v)
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0, 0.5, 0.95, 0.99, 1} {
+		want := values[int(q*float64(len(values)-1))]
+		got := s.Quantile(q)
+		if math.Abs(got-want)/want > accuracy {
+			t.Fatalf("TestSketchQuantile: q%v expected %v got %v", q, want, got)
+		}
+	}
+}
+
+func TestSketchZero(t *testing.T) {
+	s, _ := NewSketch(0.01)
+	if s.Quantile(0.5) != 0 {
+		t.Fatal("TestSketchZero: expected 0 for an empty sketch")
+	}
+	s.Add(0)
+	s.Add(-1)
+	s.Add(1)
+	if s.Quantile(0.5) != 0 || math.Abs(s.Quantile(1)-1) > 0.01 {
+		t.Fatalf("TestSketchZero: unexpected quantiles %v %v", s.Quantile(0.5), s.Quantile(1))
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, _ := NewSketch(0.01)
+	b, _ := NewSketch(0.01)
+	all, _ := NewSketch(0.01)
+	for i := 1; i <= 1000; i++ {
+		v := float64(i)
+		if i%3 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		all.Add(v)
+	}
+
+	// merge through the serialized form, as a downstream consumer would
+	parsed, err := ParseSketch(b.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(parsed); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != all.Count() || a.String() != all.String() {
+		t.Fatalf("TestSketchMerge: expected %s got %s", all, a)
+	}
+
+	other, _ := NewSketch(0.05)
+	if err := a.Merge(other); err == nil {
+		t.Fatal("TestSketchMerge: expected an error merging sketches with different accuracy")
+	}
+}
+
+func TestParseSketch(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"valid", `{"gamma":1.02,"zero":1,"bins":[[-3,2],[10,1]]}`, false},
+		{"invalid JSON", `{"gamma":`, true},
+		{"invalid gamma", `{"gamma":0.5,"zero":0,"bins":[]}`, true},
+		{"negative count", `{"gamma":1.02,"zero":0,"bins":[[1,-1]]}`, true},
+	}
+	for _, test := range tests {
+		s, err := ParseSketch(test.s)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("TestParseSketch %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+		if err == nil && s.Count() != 4 {
+			t.Fatalf("TestParseSketch %s: expected 4 values, got %d", test.name, s.Count())
+		}
+	}
+}
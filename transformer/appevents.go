@@ -0,0 +1,110 @@
+package transformer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+// Types of the app lifecycle events, written in the event tag of the
+// app_event points.
+const (
+	AppEventCrash          = "crash"
+	AppEventRestart        = "restart"
+	AppEventExit           = "exit"
+	AppEventStagingStarted = "staging_started"
+	AppEventStaged         = "staged"
+	AppEventStagingFailed  = "staging_failed"
+	AppEventSSH            = "ssh"
+	AppEventLogDropped     = "log_dropped"
+)
+
+// appEventPattern recognizes a CF system log message. If field is set, the
+// first group of the regexp is stored in it as an integer.
+type appEventPattern struct {
+	source string // prefix of the source type
+	re     *regexp.Regexp
+	event  string
+	field  string
+}
+
+// appEventPatterns are the known CF system log messages, checked in order.
+var appEventPatterns = []appEventPattern{
+	// e.g. App instance exited with guid 4e7a... payload: {"instance"=>"", "index"=>0, "reason"=>"CRASHED", ..., "crash_count"=>1, ...}
+	{"API", regexp.MustCompile(`^App instance exited with guid .*"reason"=>"CRASHED".*"crash_count"=>(\d+)`), AppEventCrash, "crash_count"},
+	{"API", regexp.MustCompile(`^App instance exited with guid .*"reason"=>"CRASHED"`), AppEventCrash, ""},
+	{"API", regexp.MustCompile(`^Restart(ed|ing) app with guid `), AppEventRestart, ""},
+	// e.g. Exit status 137 (out of memory)
+	{"CELL", regexp.MustCompile(`^Exit status (-?\d+)`), AppEventExit, "exit_status"},
+	// the first message of the stager: Creating container on Diego, or
+	// Staging... on older stagers
+	{"STG", regexp.MustCompile(`^(Staging\.\.\.|Creating container)$`), AppEventStagingStarted, ""},
+	{"STG", regexp.MustCompile(`^Staging complete$`), AppEventStaged, ""},
+	// e.g. Staging failed: Exited with status 223
+	{"STG", regexp.MustCompile(`^Staging failed`), AppEventStagingFailed, ""},
+	// e.g. Successful remote access by 10.0.16.23:51392
+	{"SSH", regexp.MustCompile(`^Successful remote access by `), AppEventSSH, ""},
+	// e.g. Log message output is too high. 100 log messages have been dropped.
+	{"LGR", regexp.MustCompile(`(\d+) (log )?messages have been dropped`), AppEventLogDropped, "dropped"},
+	// e.g. app instance exceeded log rate limit (100 log-lines/sec) set by platform operator
+	{"LGR", regexp.MustCompile(`(?i)^(log message output is too high|app instance exceeded log rate limit)`), AppEventLogDropped, ""},
+}
+
+// isAppEventLog returns true if the log message is a CF system message about
+// the app (e.g. from the API, the cells or the stager).
+func isAppEventLog(e *events.LogMessage) bool {
+	for _, prefix := range []string{"API", "CELL", "STG", "LGR", "SSH"} {
+		if strings.HasPrefix(e.GetSourceType(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertAppEvent converts the known CF system log messages about the app
+// into "app_event" points, tagged by event type. Unknown messages are
+// discarded.
+func convertAppEvent(e *events.LogMessage, meta enricher.AppMetadata) (*Point, error) {
+	msg := strings.TrimSpace(string(e.GetMessage()))
+	for _, p := range appEventPatterns {
+		if !strings.HasPrefix(e.GetSourceType(), p.source) {
+			continue
+		}
+		m := p.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+
+		fields := map[string]interface{}{
+			"count": 1,
+		}
+		if p.field != "" {
+			if v, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				fields[p.field] = v
+			}
+		}
+		return NewPoint(
+			"app_event",
+			withAppTags(meta, map[string]string{
+				"app":         meta.App,
+				"app_guid":    meta.AppGUID,
+				"space":       meta.Space,
+				"space_guid":  meta.SpaceGUID,
+				"org":         meta.Org,
+				"org_guid":    meta.OrgGUID,
+				"foundation":  meta.Foundation,
+				"instance":    e.GetSourceInstance(),
+				"source_type": e.GetSourceType(),
+				"event":       p.event,
+			}),
+			fields,
+			time.Unix(0, e.GetTimestamp()),
+			KindEvent,
+		)
+	}
+	return nil, ErrEventDiscarded
+}
@@ -0,0 +1,83 @@
+package transformer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func systemLog(source, msg string) *events.Envelope {
+	eventType := events.Envelope_LogMessage
+	messageType := events.LogMessage_OUT
+	origin := "cloud_controller"
+	ts := int64(123456789012345000)
+	instance := "0"
+	return &events.Envelope{
+		Origin:    &origin,
+		EventType: &eventType,
+		LogMessage: &events.LogMessage{
+			Message:        []byte(msg),
+			MessageType:    &messageType,
+			Timestamp:      &ts,
+			SourceType:     &source,
+			SourceInstance: &instance,
+		},
+	}
+}
+
+func TestAppEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		msg        string
+		wantEvent  string
+		wantFields map[string]interface{}
+	}{
+		{"crash", "API", `App instance exited with guid 4e7a12b3-3e8f-4b8a-9bd0-57c2a8a5f2cc payload: {"instance"=>"", "index"=>0, "cell_id"=>"", "reason"=>"CRASHED", "exit_description"=>"APP/PROC/WEB: Exited with status 1", "crash_count"=>3, "crash_timestamp"=>1564573385069145600, "version"=>"4c1f"}`, AppEventCrash, map[string]interface{}{"count": int64(1), "crash_count": int64(3)}},
+		{"crash without count", "API", `App instance exited with guid 4e7a12b3 payload: {"reason"=>"CRASHED"}`, AppEventCrash, map[string]interface{}{"count": int64(1)}},
+		{"stopped instance", "API", `App instance exited with guid 4e7a12b3 payload: {"reason"=>"STOPPED"}`, "", nil},
+		{"restart", "API", "Restarted app with guid 4e7a12b3-3e8f-4b8a-9bd0-57c2a8a5f2cc", AppEventRestart, map[string]interface{}{"count": int64(1)}},
+		{"exit", "CELL", "Exit status 137 (out of memory)", AppEventExit, map[string]interface{}{"count": int64(1), "exit_status": int64(137)}},
+		{"sidecar exit", "CELL/SIDECAR", "Exit status 0", AppEventExit, map[string]interface{}{"count": int64(1), "exit_status": int64(0)}},
+		{"staging started", "STG", "Creating container", AppEventStagingStarted, map[string]interface{}{"count": int64(1)}},
+		{"staging started on older stagers", "STG", "Staging...", AppEventStagingStarted, map[string]interface{}{"count": int64(1)}},
+		{"staging progress", "STG", "Successfully created container", "", nil},
+		{"staged", "STG", "Staging complete", AppEventStaged, map[string]interface{}{"count": int64(1)}},
+		{"staging failed", "STG", "Staging failed: Exited with status 223", AppEventStagingFailed, map[string]interface{}{"count": int64(1)}},
+		{"ssh", "SSH", "Successful remote access by 10.0.16.23:51392", AppEventSSH, map[string]interface{}{"count": int64(1)}},
+		{"ssh ended", "SSH", "Remote access ended for 10.0.16.23:51392", "", nil},
+		{"log dropped", "LGR", "Log message output is too high. 100 log messages have been dropped.", AppEventLogDropped, map[string]interface{}{"count": int64(1), "dropped": int64(100)}},
+		{"log rate limit", "LGR", "App instance exceeded log rate limit (1024 bytes/sec)", AppEventLogDropped, map[string]interface{}{"count": int64(1)}},
+		{"log too high without count", "LGR", "Log message output is too high.", AppEventLogDropped, map[string]interface{}{"count": int64(1)}},
+		{"other loggregator message", "LGR", "Syslog drain connection dropped, reconnecting", "", nil},
+		{"unknown", "CELL", "Cell 8d8d7c2f creating container for instance 52c7a0b9", "", nil},
+	}
+
+	tr := NewTransformer(ConfigTransformer{AppEvents: true}, nil, nil, nil, nil)
+	for _, test := range tests {
+		p, err := tr.ToPoint(&Envelope{Event: systemLog(test.source, test.msg), Meta: appMeta})
+		if test.wantEvent == "" {
+			if err != ErrEventDiscarded {
+				t.Fatalf("TestAppEvents %s: expected the message to be discarded, got %v, %v", test.name, p, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestAppEvents %s: %v", test.name, err)
+		}
+		if p.Name != "app_event" || p.Tags["event"] != test.wantEvent || p.Tags["source_type"] != test.source || p.Tags["app"] != appMeta.App {
+			t.Fatalf("TestAppEvents %s: unexpected point %v", test.name, p)
+		}
+		if !reflect.DeepEqual(p.Fields, test.wantFields) {
+			t.Fatalf("TestAppEvents %s: expected fields %v, got %v", test.name, test.wantFields, p.Fields)
+		}
+	}
+}
+
+func TestAppEventsDisabled(t *testing.T) {
+	p, err := ToPoint(&Envelope{Event: systemLog("STG", "Staging complete"), Meta: appMeta})
+	if err != ErrEventDiscarded {
+		t.Fatalf("TestAppEventsDisabled: expected the message to be discarded, got %v, %v", p, err)
+	}
+}
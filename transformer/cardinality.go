@@ -0,0 +1,278 @@
+package transformer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CardinalityAction is what the CardinalityLimiter does with the points of
+// new series over the budget.
+type CardinalityAction string
+
+const (
+	CardinalityDrop     CardinalityAction = "drop"     // drop the points
+	CardinalityCollapse CardinalityAction = "collapse" // set their tags to OtherTagValue
+)
+
+// Decode implements envconfig.Decoder
+func (a *CardinalityAction) Decode(value string) error {
+	switch v := CardinalityAction(value); v {
+	case CardinalityDrop, CardinalityCollapse:
+		*a = v
+		return nil
+	}
+	return errors.Errorf("unknown cardinality action %q", value)
+}
+
+// OtherTagValue is the value of the tags collapsed by the CardinalityLimiter.
+const OtherTagValue = "__other__"
+
+// maxOffenderApps is the maximum number of apps reported for each offender.
+const maxOffenderApps = 20
+
+// cardinalityPruneSteps is how many times per window the series of a budget
+// over the limit can be expired, instead of at every rejected point. The
+// rejected points are also counted in steps of the same length.
+const cardinalityPruneSteps = 60
+
+type ConfigCardinality struct {
+	MaxSeries int               `default:"0" desc:"Maximum number of distinct series per measurement and org in the window (if 0, there is no limit)"` // CFMR_CARDINALITY_MAXSERIES
+	Window    time.Duration     `default:"1h" desc:"Sliding window in which the distinct series are counted"`                                          // CFMR_CARDINALITY_WINDOW
+	Action    CardinalityAction `default:"collapse" desc:"What to do with the points of new series over the limit (drop or collapse)"`                 // CFMR_CARDINALITY_ACTION
+	KeepTags  []string          `default:"org,org_guid,space,space_guid,foundation" desc:"Tags kept when collapsing a series"`                         // CFMR_CARDINALITY_KEEPTAGS
+}
+
+// CardinalityCallback is called for every point over the budget.
+type CardinalityCallback func(measurement, org string)
+
+// CardinalityOffender is a measurement and org over the series budget.
+type CardinalityOffender struct {
+	Measurement  string            `json:"measurement"`
+	Org          string            `json:"org"`
+	Series       int               `json:"series"`
+	Rejected     uint64            `json:"rejected"` // points rejected in the window
+	LastRejected time.Time         `json:"last_rejected"`
+	Apps         map[string]uint64 `json:"apps"` // points rejected by app in the window
+}
+
+// CardinalityLimiter limits the number of distinct series written for each
+// measurement and org in a sliding window, so that a single app creating
+// unbounded tags can not blow up the number of series of the output. The
+// series are counted as written, i.e. after the tag policy of the output.
+type CardinalityLimiter struct {
+	cfg    ConfigCardinality
+	policy TagPolicy
+	keep   map[string]bool
+	cb     CardinalityCallback
+	now    func() time.Time
+
+	l         sync.Mutex
+	budgets   map[budgetKey]*budget
+	lastPrune time.Time
+}
+
+type budgetKey struct {
+	measurement, org string
+}
+
+type budget struct {
+	series       map[string]time.Time // series -> last seen
+	rejections   []*rejectionStep     // oldest first
+	lastRejected time.Time
+	lastPrune    time.Time
+}
+
+// rejectionStep counts the points rejected from start, for a step of the
+// window.
+type rejectionStep struct {
+	start    time.Time
+	rejected uint64
+	apps     map[string]uint64
+}
+
+// NewCardinalityLimiter creates a CardinalityLimiter counting the series with
+// the tag policy of the output applied. The callback is optional.
+func NewCardinalityLimiter(cfg ConfigCardinality, policy TagPolicy, cb CardinalityCallback) (*CardinalityLimiter, error) {
+	if cfg.MaxSeries <= 0 {
+		return nil, errors.New("the maximum number of series must be positive")
+	}
+	if cfg.Window <= 0 {
+		return nil, errors.New("the cardinality window must be positive")
+	}
+	keep := make(map[string]bool, len(cfg.KeepTags))
+	for _, t := range cfg.KeepTags {
+		keep[t] = true
+	}
+	return &CardinalityLimiter{
+		cfg:     cfg,
+		policy:  policy,
+		keep:    keep,
+		cb:      cb,
+		now:     time.Now,
+		budgets: make(map[budgetKey]*budget),
+	}, nil
+}
+
+// Limit applies the limit to the points of the envelope (see Transform). It
+// returns false if no points are left.
+func (c *CardinalityLimiter) Limit(e *Envelope) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastPrune) >= c.cfg.Window {
+		c.pruneWithLock(now)
+	}
+
+	ps := e.Points()
+	kept := ps[:0]
+	for _, p := range ps {
+		if c.allowWithLock(p, now) {
+			kept = append(kept, p)
+		}
+	}
+	e.Output = kept
+	return len(kept) > 0
+}
+
+// allowWithLock returns false if the point must be dropped; it collapses its
+// tags if needed.
+func (c *CardinalityLimiter) allowWithLock(p *Point, now time.Time) bool {
+	key := budgetKey{p.Name, p.Tags["org"]}
+	b, ok := c.budgets[key]
+	if !ok {
+		b = &budget{series: make(map[string]time.Time)}
+		c.budgets[key] = b
+	}
+
+	series := c.policy.Apply(p).Series()
+	if _, ok := b.series[series]; ok || len(b.series) < c.cfg.MaxSeries {
+		b.series[series] = now
+		return true
+	}
+	if now.Sub(b.lastPrune) >= c.cfg.Window/cardinalityPruneSteps {
+		b.lastPrune = now
+		b.prune(now.Add(-c.cfg.Window))
+	}
+	if len(b.series) < c.cfg.MaxSeries {
+		b.series[series] = now
+		return true
+	}
+
+	// over the budget
+	b.reject(p.Tags["app"], now, c.cfg.Window)
+	if c.cb != nil {
+		c.cb(key.measurement, key.org)
+	}
+
+	if c.cfg.Action == CardinalityDrop {
+		return false
+	}
+	tags := make(map[string]string, len(p.Tags))
+	for k, v := range p.Tags {
+		if c.keep[k] {
+			tags[k] = v
+		} else {
+			tags[k] = OtherTagValue
+		}
+	}
+	p.Tags = tags
+	return true
+}
+
+// pruneWithLock forgets the series not seen in the window, and the
+// measurements and orgs without series nor recent rejections.
+func (c *CardinalityLimiter) pruneWithLock(now time.Time) {
+	c.lastPrune = now
+	expire := now.Add(-c.cfg.Window)
+	for k, b := range c.budgets {
+		b.prune(expire)
+		if len(b.series) == 0 && b.lastRejected.Before(expire) {
+			delete(c.budgets, k)
+		}
+	}
+}
+
+// reject counts a point of the app rejected at now.
+func (b *budget) reject(app string, now time.Time, window time.Duration) {
+	b.lastRejected = now
+	n := len(b.rejections)
+	if n == 0 || now.Sub(b.rejections[n-1].start) >= window/cardinalityPruneSteps {
+		b.rejections = append(b.rejections, &rejectionStep{start: now, apps: make(map[string]uint64)})
+		b.pruneRejections(now.Add(-window))
+	}
+	step := b.rejections[len(b.rejections)-1]
+	step.rejected++
+	if _, ok := step.apps[app]; ok || len(step.apps) < maxOffenderApps {
+		step.apps[app]++
+	}
+}
+
+func (b *budget) prune(expire time.Time) {
+	for s, t := range b.series {
+		if t.Before(expire) {
+			delete(b.series, s)
+		}
+	}
+	b.pruneRejections(expire)
+}
+
+// pruneRejections forgets the steps started before expire.
+func (b *budget) pruneRejections(expire time.Time) {
+	i := 0
+	for i < len(b.rejections) && b.rejections[i].start.Before(expire) {
+		i++
+	}
+	if i > 0 {
+		b.rejections = append(b.rejections[:0], b.rejections[i:]...)
+	}
+}
+
+// Offenders returns the measurements and orgs over the budget in the window,
+// the ones with most points rejected in the window first.
+func (c *CardinalityLimiter) Offenders() []CardinalityOffender {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	expire := c.now().Add(-c.cfg.Window)
+	offenders := []CardinalityOffender{}
+	for k, b := range c.budgets {
+		var rejected uint64
+		apps := make(map[string]uint64)
+		for _, step := range b.rejections {
+			if step.start.Before(expire) {
+				continue
+			}
+			rejected += step.rejected
+			for a, n := range step.apps {
+				if _, ok := apps[a]; ok || len(apps) < maxOffenderApps {
+					apps[a] += n
+				}
+			}
+		}
+		if rejected == 0 {
+			continue
+		}
+		offenders = append(offenders, CardinalityOffender{
+			Measurement:  k.measurement,
+			Org:          k.org,
+			Series:       len(b.series),
+			Rejected:     rejected,
+			LastRejected: b.lastRejected,
+			Apps:         apps,
+		})
+	}
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].Rejected != offenders[j].Rejected {
+			return offenders[i].Rejected > offenders[j].Rejected
+		}
+		if offenders[i].Measurement != offenders[j].Measurement {
+			return offenders[i].Measurement < offenders[j].Measurement
+		}
+		return offenders[i].Org < offenders[j].Org
+	})
+	return offenders
+}
@@ -0,0 +1,142 @@
+package transformer
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func cardinalityEnvelope(org, app, name string) *Envelope {
+	return &Envelope{Output: []*Point{{
+		Name:   "app_metric",
+		Tags:   map[string]string{"org": org, "app": app, "name": name},
+		Fields: map[string]interface{}{"value": 1.0},
+		Kind:   KindGauge,
+	}}}
+}
+
+func TestCardinalityLimiter(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tests := []struct {
+		name     string
+		action   CardinalityAction
+		wantKept bool
+		wantTags map[string]string
+	}{
+		{"drop", CardinalityDrop, false, nil},
+		{"collapse", CardinalityCollapse, true, map[string]string{"org": "org", "app": OtherTagValue, "name": OtherTagValue}},
+	}
+
+	for _, test := range tests {
+		limited := 0
+		c, err := NewCardinalityLimiter(ConfigCardinality{
+			MaxSeries: 2,
+			Window:    time.Hour,
+			Action:    test.action,
+			KeepTags:  []string{"org"},
+		}, TagPolicy{}, func(measurement, org string) {
+			limited++
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.now = func() time.Time { return now }
+
+		for i := 0; i < 2; i++ {
+			if !c.Limit(cardinalityEnvelope("org", "app", strconv.Itoa(i))) {
+				t.Fatalf("TestCardinalityLimiter %s: expected series %d to be kept", test.name, i)
+			}
+		}
+		// known series and other orgs are not limited
+		if !c.Limit(cardinalityEnvelope("org", "app", "0")) || !c.Limit(cardinalityEnvelope("other", "app", "2")) {
+			t.Fatalf("TestCardinalityLimiter %s: expected known series and other orgs to be kept", test.name)
+		}
+
+		e := cardinalityEnvelope("org", "bad-app", "2")
+		if kept := c.Limit(e); kept != test.wantKept {
+			t.Fatalf("TestCardinalityLimiter %s: expected kept %v", test.name, test.wantKept)
+		}
+		if test.wantKept && !reflect.DeepEqual(e.Points()[0].Tags, test.wantTags) {
+			t.Fatalf("TestCardinalityLimiter %s: expected tags %v got %v", test.name, test.wantTags, e.Points()[0].Tags)
+		}
+		if limited != 1 {
+			t.Fatalf("TestCardinalityLimiter %s: expected 1 limited point, got %d", test.name, limited)
+		}
+
+		want := []CardinalityOffender{{
+			Measurement:  "app_metric",
+			Org:          "org",
+			Series:       2,
+			Rejected:     1,
+			LastRejected: now,
+			Apps:         map[string]uint64{"bad-app": 1},
+		}}
+		if got := c.Offenders(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("TestCardinalityLimiter %s: expected offenders %v got %v", test.name, want, got)
+		}
+
+		// only the points rejected in the window are counted
+		for _, d := range []time.Duration{45 * time.Minute, 30 * time.Minute} {
+			now = now.Add(d)
+			for i := 0; i < 2; i++ {
+				c.Limit(cardinalityEnvelope("org", "app", strconv.Itoa(i)))
+			}
+			if d == 45*time.Minute {
+				c.Limit(cardinalityEnvelope("org", "other-app", "2"))
+			}
+		}
+		want[0].Rejected = 1
+		want[0].LastRejected = now.Add(-30 * time.Minute)
+		want[0].Apps = map[string]uint64{"other-app": 1}
+		if got := c.Offenders(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("TestCardinalityLimiter %s: expected offenders %v in the window got %v", test.name, want, got)
+		}
+
+		// the series expire after the window
+		now = now.Add(2 * time.Hour)
+		if !c.Limit(cardinalityEnvelope("org", "app", "3")) {
+			t.Fatalf("TestCardinalityLimiter %s: expected a new series to be kept after the window", test.name)
+		}
+		if got := c.Offenders(); len(got) != 0 {
+			t.Fatalf("TestCardinalityLimiter %s: expected no offenders after the window, got %v", test.name, got)
+		}
+	}
+}
+
+func TestCardinalityLimiterTagPolicy(t *testing.T) {
+	c, err := NewCardinalityLimiter(ConfigCardinality{
+		MaxSeries: 1,
+		Window:    time.Hour,
+		Action:    CardinalityDrop,
+	}, TagPolicy{Drop: []string{"app"}, Lowercase: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the series only differ by tags that are not written
+	for _, app := range []string{"app", "app-venerable"} {
+		if !c.Limit(cardinalityEnvelope("org", app, "queue")) || !c.Limit(cardinalityEnvelope("org", app, "QUEUE")) {
+			t.Fatalf("TestCardinalityLimiterTagPolicy: expected the points of %s to be kept", app)
+		}
+	}
+	if c.Limit(cardinalityEnvelope("org", "app", "other")) {
+		t.Fatal("TestCardinalityLimiterTagPolicy: expected a new series to be dropped")
+	}
+	// the points are written with their tags
+	e := cardinalityEnvelope("org", "app", "QUEUE")
+	c.Limit(e)
+	if want := map[string]string{"org": "org", "app": "app", "name": "QUEUE"}; !reflect.DeepEqual(e.Points()[0].Tags, want) {
+		t.Fatalf("TestCardinalityLimiterTagPolicy: expected tags %v got %v", want, e.Points()[0].Tags)
+	}
+}
+
+func TestNewCardinalityLimiter(t *testing.T) {
+	if _, err := NewCardinalityLimiter(ConfigCardinality{Window: time.Hour}, TagPolicy{}, nil); err == nil {
+		t.Fatal("TestNewCardinalityLimiter: expected an error without a maximum number of series")
+	}
+	var a CardinalityAction
+	if err := a.Decode("sample"); err == nil {
+		t.Fatal("TestNewCardinalityLimiter: expected an error for an unknown action")
+	}
+}
@@ -0,0 +1,244 @@
+package transformer
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CollisionStrategy is how points of the same series with the same timestamp
+// are kept apart, as InfluxDB would store only the last of them.
+type CollisionStrategy string
+
+const (
+	CollisionNone      CollisionStrategy = "none"      // keep the points as they are
+	CollisionSeq       CollisionStrategy = "seq"       // add a seq tag to the colliding points
+	CollisionNudge     CollisionStrategy = "nudge"     // move the colliding points by 1ns
+	CollisionAggregate CollisionStrategy = "aggregate" // merge the colliding points into one
+)
+
+// SeqTag is the tag added by CollisionSeq.
+const SeqTag = "seq"
+
+// Decode implements envconfig.Decoder
+func (s *CollisionStrategy) Decode(value string) error {
+	switch v := CollisionStrategy(value); v {
+	case CollisionNone, CollisionSeq, CollisionNudge, CollisionAggregate:
+		*s = v
+		return nil
+	}
+	return errors.Errorf("unknown collision strategy %q", value)
+}
+
+// Collisions selects the CollisionStrategy of each measurement; measurements
+// not listed are left as they are.
+type Collisions map[string]CollisionStrategy
+
+// Measurements returns the measurements with a strategy other than
+// CollisionNone, sorted.
+func (c Collisions) Measurements() []string {
+	var ms []string
+	for m, s := range c {
+		if s != "" && s != CollisionNone {
+			ms = append(ms, m)
+		}
+	}
+	sort.Strings(ms)
+	return ms
+}
+
+// Resolve returns the points of a batch with the collisions resolved. The
+// points are not modified and the result only depends on their order, so
+// that retrying a batch writes the same points.
+//
+// Only collisions within the batch are resolved.
+func (c Collisions) Resolve(points []*Point) []*Point {
+	if len(c) == 0 {
+		return points
+	}
+
+	res := make([]*Point, 0, len(points))
+	seen := make(map[string]int)            // series and timestamp -> count (seq) or index in res (aggregate)
+	used := make(map[string]map[int64]bool) // series -> timestamps (nudge)
+	for _, p := range points {
+		strategy := c[p.Name]
+		if strategy == "" || strategy == CollisionNone {
+			res = append(res, p)
+			continue
+		}
+
+		series := p.Series()
+		key := series + " " + strconv.FormatInt(p.Time.UnixNano(), 10)
+		switch strategy {
+		case CollisionSeq:
+			n := seen[key]
+			seen[key]++
+			if n > 0 {
+				p = p.withTag(SeqTag, strconv.Itoa(n))
+			}
+
+		case CollisionNudge:
+			ts, ok := used[series]
+			if !ok {
+				ts = make(map[int64]bool)
+				used[series] = ts
+			}
+			t := p.Time.UnixNano()
+			for ts[t] {
+				t++
+			}
+			ts[t] = true
+			if t != p.Time.UnixNano() {
+				p = p.at(t)
+			}
+
+		case CollisionAggregate:
+			merged := false
+			for i, ok := seen[key]; ok; i, ok = seen[key] {
+				if m, ok := res[i].merge(p); ok {
+					res[i], merged = m, true
+					break
+				}
+				// e.g. different sample rates: keep the point apart
+				p = p.at(p.Time.UnixNano() + 1)
+				key = series + " " + strconv.FormatInt(p.Time.UnixNano(), 10)
+			}
+			if merged {
+				continue
+			}
+			seen[key] = len(res)
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// withTag returns a copy of the point with the tag added.
+func (p *Point) withTag(k, v string) *Point {
+	tags := make(map[string]string, len(p.Tags)+1)
+	for tk, tv := range p.Tags {
+		tags[tk] = tv
+	}
+	tags[k] = v
+	c := *p
+	c.Tags = tags
+	return &c
+}
+
+// at returns a copy of the point with the timestamp (in ns) changed.
+func (p *Point) at(t int64) *Point {
+	c := *p
+	c.Time = time.Unix(0, t).In(p.Time.Location())
+	return &c
+}
+
+var percentileFieldRe = regexp.MustCompile(`_p[0-9.]+$`)
+
+// mergeable returns false if the point has fields that can't be combined
+// with the ones of another point: percentiles and sketches.
+func (p *Point) mergeable() bool {
+	for k := range p.Fields {
+		if percentileFieldRe.MatchString(k) || strings.HasSuffix(k, "_sketch") {
+			return false
+		}
+	}
+	return true
+}
+
+// additiveField returns true if the values of the field of two points can be
+// added: counts, sums and sizes.
+func additiveField(k string) bool {
+	if k == "count" || k == "size" || strings.HasPrefix(k, "bytes_") {
+		return true
+	}
+	for _, suffix := range []string{"_count", "_sum", "_size", "_bytes"} {
+		if strings.HasSuffix(k, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// weight returns the number of events the point stands for (its count).
+func (p *Point) weight() float64 {
+	if c, ok := p.Fields["count"].(int64); ok && c > 0 {
+		return float64(c)
+	}
+	return 1
+}
+
+// merge returns a copy of the point with the fields of o merged: additive
+// fields are summed, _min and _max fields keep the minimum and the maximum,
+// and the other numeric fields (e.g. _mean, durations and gauges) the mean
+// weighted by count; the other fields keep their value. It returns false if
+// the points can't be merged, as they have different sample rates or
+// percentiles.
+func (p *Point) merge(o *Point) (*Point, bool) {
+	if p.Fields["sample_rate"] != o.Fields["sample_rate"] || !p.mergeable() || !o.mergeable() {
+		return nil, false
+	}
+	pw, ow := p.weight(), o.weight()
+	mean := func(a, b float64) float64 { return (a*pw + b*ow) / (pw + ow) }
+
+	fields := make(map[string]interface{}, len(p.Fields))
+	for k, v := range p.Fields {
+		fields[k] = v
+	}
+	for k, v := range o.Fields {
+		cur, ok := fields[k]
+		if !ok {
+			fields[k] = v
+			continue
+		}
+		switch {
+		case k == "sample_rate":
+		case additiveField(k):
+			fields[k] = add(cur, v)
+		case strings.HasSuffix(k, "_min"):
+			fields[k] = combine(cur, v, math.Min)
+		case strings.HasSuffix(k, "_max"):
+			fields[k] = combine(cur, v, math.Max)
+		default:
+			fields[k] = combine(cur, v, mean)
+		}
+	}
+	c := *p
+	c.Fields = fields
+	return &c, true
+}
+
+// add returns a + b if they are both int64 or both float64, and a otherwise.
+func add(a, b interface{}) interface{} {
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return a + b
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return a + b
+		}
+	}
+	return a
+}
+
+// combine returns f(a, b) if a and b are both int64 (the result is rounded)
+// or both float64, and a otherwise.
+func combine(a, b interface{}, f func(a, b float64) float64) interface{} {
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return int64(math.Round(f(float64(a), float64(b))))
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return f(a, b)
+		}
+	}
+	return a
+}
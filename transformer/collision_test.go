@@ -0,0 +1,155 @@
+package transformer
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// store mimics InfluxDB, which keeps only the last point of each series and
+// timestamp.
+func store(points []*Point) map[string]*Point {
+	db := make(map[string]*Point)
+	for _, p := range points {
+		db[p.Series()+" "+strconv.FormatInt(p.Time.UnixNano(), 10)] = p
+	}
+	return db
+}
+
+func TestCollisionsResolve_burst(t *testing.T) {
+	const burst = 100
+	ts := time.Unix(0, 123456789012345000)
+	tags := map[string]string{"app": "app", "instance": "0", "method": "GET", "status_code": "200"}
+
+	for _, strategy := range []CollisionStrategy{CollisionSeq, CollisionNudge, CollisionAggregate} {
+		var points []*Point
+		for i := 0; i < burst; i++ {
+			p, err := NewPoint("http_request", tags, map[string]interface{}{"count": 1, "size": 10}, ts, KindEvent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points = append(points, p)
+		}
+
+		resolved := Collisions{"http_request": strategy}.Resolve(points)
+		var count, size int64
+		for _, p := range store(resolved) {
+			count += p.Fields["count"].(int64)
+			size += p.Fields["size"].(int64)
+		}
+		if count != burst || size != burst*10 {
+			t.Fatalf("TestCollisionsResolve_burst %s: expected count %d and size %d, got %d and %d", strategy, burst, burst*10, count, size)
+		}
+
+		// the input points are not modified, so retries write the same points
+		if again := (Collisions{"http_request": strategy}).Resolve(points); !reflect.DeepEqual(again, resolved) {
+			t.Fatalf("TestCollisionsResolve_burst %s: resolving again gave different points", strategy)
+		}
+	}
+
+	// without a strategy the points overwrite each other
+	var points []*Point
+	for i := 0; i < burst; i++ {
+		p, _ := NewPoint("http_request", tags, map[string]interface{}{"count": 1}, ts, KindEvent)
+		points = append(points, p)
+	}
+	if db := store(Collisions{}.Resolve(points)); len(db) != 1 {
+		t.Fatalf("TestCollisionsResolve_burst: expected 1 stored point without strategy, got %d", len(db))
+	}
+}
+
+func TestCollisionsResolve(t *testing.T) {
+	ts := time.Unix(0, 1000)
+	point := func(name string, tags map[string]string, t time.Time, count int) *Point {
+		return &Point{Name: name, Tags: tags, Fields: map[string]interface{}{"count": int64(count)}, Time: t, Kind: KindEvent}
+	}
+	a := map[string]string{"instance": "0"}
+	b := map[string]string{"instance": "1"}
+
+	tests := []struct {
+		name   string
+		c      Collisions
+		points []*Point
+		want   []*Point
+	}{
+		{
+			"seq",
+			Collisions{"log": CollisionSeq},
+			[]*Point{point("log", a, ts, 1), point("log", b, ts, 1), point("log", a, ts, 1)},
+			[]*Point{point("log", a, ts, 1), point("log", b, ts, 1), point("log", map[string]string{"instance": "0", "seq": "1"}, ts, 1)},
+		},
+		{
+			"nudge",
+			Collisions{"log": CollisionNudge},
+			[]*Point{point("log", a, ts, 1), point("log", a, ts, 1), point("log", a, ts.Add(1), 1)},
+			[]*Point{point("log", a, ts, 1), point("log", a, ts.Add(1), 1), point("log", a, ts.Add(2), 1)},
+		},
+		{
+			"aggregate",
+			Collisions{"log": CollisionAggregate},
+			[]*Point{point("log", a, ts, 1), point("log", b, ts, 1), point("log", a, ts, 2)},
+			[]*Point{point("log", a, ts, 3), point("log", b, ts, 1)},
+		},
+		{
+			"aggregate rollups",
+			Collisions{"http_request": CollisionAggregate},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "duration_sum": 0.5, "duration_min": 0.5, "duration_max": 0.5, "duration_mean": 0.5, "response_size_sum": 100.0}},
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(3), "duration_sum": 0.75, "duration_min": 0.125, "duration_max": 0.5, "duration_mean": 0.25, "response_size_sum": 300.0}},
+			},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(4), "duration_sum": 1.25, "duration_min": 0.125, "duration_max": 0.5, "duration_mean": 0.3125, "response_size_sum": 400.0}},
+			},
+		},
+		{
+			"aggregate sampled",
+			Collisions{"http_request": CollisionAggregate},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "duration": 0.25, "response_size": int64(10), "sample_rate": 0.1}},
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "duration": 0.75, "response_size": int64(20), "sample_rate": 0.1}},
+			},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(2), "duration": 0.5, "response_size": int64(30), "sample_rate": 0.1}},
+			},
+		},
+		{
+			"aggregate different sample rates",
+			Collisions{"http_request": CollisionAggregate},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "sample_rate": 0.1}},
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "sample_rate": 0.5}},
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1)}},
+			},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "sample_rate": 0.1}},
+				{Name: "http_request", Tags: a, Time: ts.Add(1), Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1), "sample_rate": 0.5}},
+				{Name: "http_request", Tags: a, Time: ts.Add(2), Kind: KindEvent, Fields: map[string]interface{}{"count": int64(1)}},
+			},
+		},
+		{
+			"aggregate percentiles",
+			Collisions{"http_request": CollisionAggregate},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(2), "duration_p99": 0.2}},
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(2), "duration_p99": 0.5}},
+			},
+			[]*Point{
+				{Name: "http_request", Tags: a, Time: ts, Kind: KindEvent, Fields: map[string]interface{}{"count": int64(2), "duration_p99": 0.2}},
+				{Name: "http_request", Tags: a, Time: ts.Add(1), Kind: KindEvent, Fields: map[string]interface{}{"count": int64(2), "duration_p99": 0.5}},
+			},
+		},
+		{
+			"other measurement",
+			Collisions{"log": CollisionAggregate},
+			[]*Point{point("instance", a, ts, 1), point("instance", a, ts, 1)},
+			[]*Point{point("instance", a, ts, 1), point("instance", a, ts, 1)},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.c.Resolve(test.points); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("TestCollisionsResolve %s: expected %v got %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package transformer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+type ConfigTransformer struct {
+	MetricNameAsMeasurement bool          `default:"false" desc:"Use the name of value metrics and counters as measurement instead of a name tag"`                                                        // CFMR_TRANSFORMER_METRICNAMEASMEASUREMENT
+	LogLevel                bool          `default:"false" desc:"Add the level of JSON app logs as level tag"`                                                                                            // CFMR_TRANSFORMER_LOGLEVEL
+	LogLevelFields          []string      `default:"level,severity" desc:"Fields of JSON app logs containing the level"`                                                                                  // CFMR_TRANSFORMER_LOGLEVELFIELDS
+	LogRulesFile            string        `desc:"Path of the JSON file with the rules converting log messages to metrics"`                                                                                // CFMR_TRANSFORMER_LOGRULESFILE
+	LogMetrics              bool          `default:"false" desc:"Convert the metrics embedded in app logs (METRIC:name:value|g|#tag:value)"`                                                              // CFMR_TRANSFORMER_LOGMETRICS
+	LogMetricsMaxSeries     int           `default:"100" desc:"Maximum number of distinct series of embedded metrics per app in the window"`                                                              // CFMR_TRANSFORMER_LOGMETRICSMAXSERIES
+	LogMetricsWindow        time.Duration `default:"1h" desc:"Sliding window in which the distinct series of embedded metrics of each app are counted"`                                                   // CFMR_TRANSFORMER_LOGMETRICSWINDOW
+	MappingFile             string        `desc:"Path of the JSON file with the measurement mapping (if empty, the default one is used)"`                                                                 // CFMR_TRANSFORMER_MAPPINGFILE
+	Collisions              Collisions    `default:"http_request:nudge,router_request:nudge,log:nudge" desc:"Strategy (none, seq, nudge or aggregate) avoiding collisions of points of each measurement"` // CFMR_TRANSFORMER_COLLISIONS
+	InstanceGUID            bool          `default:"false" desc:"Add the instance_guid tag to the points of app instances, if the event provides it"`                                                     // CFMR_TRANSFORMER_INSTANCEGUID
+	HTTPDedupe              HTTPDedupe    `default:"none" desc:"De-duplication of the HttpStartStop events of the gorouter and of the apps (none, router or correlate)"`                                  // CFMR_TRANSFORMER_HTTPDEDUPE
+	Routes                  bool          `default:"false" desc:"Add the normalized path of HttpStartStop events as route tag"`                                                                           // CFMR_TRANSFORMER_ROUTES
+	RoutesFile              string        `desc:"Path of the JSON file with the route templates of each app GUID (or * for all apps)"`                                                                    // CFMR_TRANSFORMER_ROUTESFILE
+	RoutesMaxValues         int           `default:"100" desc:"Maximum number of distinct routes per app in the window (further routes are tagged as __other__)"`                                         // CFMR_TRANSFORMER_ROUTESMAXVALUES
+	RoutesWindow            time.Duration `default:"1h" desc:"Sliding window in which the distinct routes of each app are counted"`                                                                       // CFMR_TRANSFORMER_ROUTESWINDOW
+	AppEvents               bool          `default:"false" desc:"Convert the app lifecycle messages of API, CELL, STG, LGR and SSH to app_event points"`                                                  // CFMR_TRANSFORMER_APPEVENTS
+	FoundationTag           bool          `default:"true" desc:"Add the name of the CF foundation of the app as foundation tag"`                                                                          // CFMR_TRANSFORMER_FOUNDATIONTAG
+}
+
+// Transformer converts the enriched envelopes into Points.
+type Transformer struct {
+	cfg        ConfigTransformer
+	rules      *LogRules
+	logMetrics *LogMetrics
+	mapping    Mapping
+	routes     *Routes
+}
+
+// NewTransformer creates a Transformer. The log rules, log metrics and routes
+// are optional: if nil, they are disabled. If the mapping is nil, the default
+// mapping is used.
+func NewTransformer(cfg ConfigTransformer, rules *LogRules, logMetrics *LogMetrics, mapping Mapping, routes *Routes) *Transformer {
+	return &Transformer{cfg: cfg, rules: rules, logMetrics: logMetrics, mapping: mapping, routes: routes}
+}
+
+var defaultTransformer = NewTransformer(ConfigTransformer{}, nil, nil, nil, nil)
+
+// Transform converts the envelope using the default configuration.
+func Transform(event *Envelope) error {
+	return defaultTransformer.Transform(event)
+}
+
+// ToPoint converts the envelope into a point using the default configuration.
+func ToPoint(event *Envelope) (*Point, error) {
+	return defaultTransformer.ToPoint(event)
+}
+
+// Transform converts the envelope into its points and stores them in its
+// Output. It returns ErrEventDiscarded (or ErrEmptyPoint) if the envelope
+// produced no points.
+func (t *Transformer) Transform(event *Envelope) error {
+	ps, err := t.ToPoints(event)
+	if err != nil {
+		return err
+	}
+	event.Output = ps
+	return nil
+}
+
+// ToPoints converts the envelope into its point and the points of the
+// matching log rules, if any. If there are no points, it returns
+// ErrEventDiscarded, or ErrEmptyPoint if the mapping removed all the fields of
+// the point.
+func (t *Transformer) ToPoints(event *Envelope) ([]*Point, error) {
+	event = t.withFoundation(event)
+	var ps []*Point
+	p, err := t.ToPoint(event)
+	discarded := ErrEventDiscarded
+	if err == nil {
+		ps = append(ps, p)
+	} else if err == ErrEmptyPoint {
+		discarded = err
+	} else if err != ErrEventDiscarded {
+		return nil, err
+	}
+
+	if t.rules != nil && event.Platform == nil && event.Meta.App != "" && event.Event.GetEventType() == events.Envelope_LogMessage {
+		rps, err := t.rules.convert(event.Event.GetLogMessage(), event.Meta)
+		if err != nil {
+			return nil, err
+		}
+		for _, rp := range rps {
+			ps = append(ps, withSampleRate(rp, event.SampleRate))
+		}
+	}
+
+	if len(ps) == 0 {
+		return nil, discarded
+	}
+	return ps, nil
+}
+
+// ToPoint converts the envelope into a point.
+func (t *Transformer) ToPoint(event *Envelope) (*Point, error) {
+	event = t.withFoundation(event)
+	if event.Platform != nil {
+		var p *Point
+		var err error
+		if event.Event.GetEventType() == events.Envelope_Error {
+			p, err = t.mapped(event.Event)(convertError(event.Event, event.Platform))
+		} else {
+			p, err = t.mapped(event.Event)(t.convertPlatformEvent(event.Event, event.Platform))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	if event.Meta.App == "" {
+		return nil, ErrEventDiscarded
+	}
+
+	var p *Point
+	var err error
+	switch event.Event.GetEventType() {
+	default:
+		return nil, ErrEventDiscarded
+
+	case events.Envelope_HttpStartStop:
+		e := event.Event.GetHttpStartStop()
+		if !t.keepHTTP(e) {
+			return nil, ErrEventDiscarded
+		}
+		p, err = t.withRoute(e, event.Meta)(t.withInstanceTags(event)(convertHttpStartStop(e, event.Meta)))
+		p, err = t.withRequestID(e)(t.mapped(event.Event)(p, err))
+
+	case events.Envelope_LogMessage:
+		p, err = t.convertLogMessage(event)
+
+	case events.Envelope_ContainerMetric:
+		p, err = t.mapped(event.Event)(t.withInstanceTags(event)(convertContainerMetric(event.Event.GetContainerMetric(), event.Event.GetTimestamp(), event.Meta)))
+
+	case events.Envelope_ValueMetric, events.Envelope_CounterEvent:
+		p, err = t.mapped(event.Event)(t.convertAppMetric(event.Event, event.Meta))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return withSampleRate(p, event.SampleRate), nil
+}
+
+// withFoundation returns the envelope to convert: the foundation of the app
+// is only written in the foundation tag if enabled.
+func (t *Transformer) withFoundation(event *Envelope) *Envelope {
+	if t.cfg.FoundationTag || event.Meta.Foundation == "" {
+		return event
+	}
+	e := *event
+	e.Meta.Foundation = ""
+	return &e
+}
+
+// withSampleRate records the sample rate of sampled events in the point, so
+// that counts can be re-weighted.
+func withSampleRate(p *Point, rate float64) *Point {
+	if rate > 0 && rate < 1 {
+		p.Fields["sample_rate"] = rate
+	}
+	return p
+}
+
+func convertHttpStartStop(e *events.HttpStartStop, meta enricher.AppMetadata) (*Point, error) {
+	start := time.Unix(0, e.GetStartTimestamp())
+	stop := time.Unix(0, e.GetStopTimestamp())
+
+	return NewPoint(
+		"http_request", // metric name
+		withAppTags(meta, map[string]string{ // tags
+			"app":         meta.App,
+			"app_guid":    meta.AppGUID,
+			"space":       meta.Space,
+			"space_guid":  meta.SpaceGUID,
+			"org":         meta.Org,
+			"org_guid":    meta.OrgGUID,
+			"foundation":  meta.Foundation,
+			"instance":    fmt.Sprint(e.GetInstanceIndex()),
+			"method":      e.GetMethod().String(),
+			"status_code": fmt.Sprint(e.GetStatusCode()),
+			PeerTypeTag:   strings.ToLower(e.GetPeerType().String()),
+		}),
+		map[string]interface{}{ // values
+			"count":         1, // Not needed but for convenience and furthur usage.
+			"duration":      stop.Sub(start).Seconds(),
+			"response_size": e.GetContentLength(),
+		},
+		start, // timestamp
+		KindEvent,
+	)
+}
+
+// convertLogMessage converts the metrics embedded in app logs and the app
+// lifecycle messages, if enabled, or else the log message itself.
+func (t *Transformer) convertLogMessage(event *Envelope) (*Point, error) {
+	e := event.Event.GetLogMessage()
+	if t.logMetrics != nil && isAppLog(e) {
+		if p, ok, err := t.logMetrics.convert(e, event.Meta); ok {
+			return p, err
+		}
+	}
+	if t.cfg.AppEvents && isAppEventLog(e) {
+		return t.mapped(event.Event)(convertAppEvent(e, event.Meta))
+	}
+	p, err := convertLogMessage(e, event.Meta, t.logLevel(e))
+	if isAppLog(e) {
+		p, err = t.withInstanceTags(event)(p, err)
+	}
+	return t.mapped(event.Event)(p, err)
+}
+
+// withInstanceTags returns a function adding the process type and, if
+// enabled, the instance GUID tags to the result of a converter. Tags that
+// can't be derived from the envelope are not added.
+func (t *Transformer) withInstanceTags(event *Envelope) func(*Point, error) (*Point, error) {
+	return func(p *Point, err error) (*Point, error) {
+		if err != nil {
+			return p, err
+		}
+		if pt := processType(event); pt != "" {
+			p.Tags["process_type"] = pt
+		}
+		if id := instanceGUID(event.Event); t.cfg.InstanceGUID && id != "" {
+			p.Tags["instance_guid"] = id
+		}
+		return p, nil
+	}
+}
+
+// processType returns the type of the process that emitted the event: the one
+// found by the enricher, the one in the envelope tags or the one in the source
+// type of app logs (e.g. APP/PROC/WEB), in this order.
+func processType(event *Envelope) string {
+	if event.Meta.ProcessType != "" {
+		return event.Meta.ProcessType
+	}
+	if pt := event.Event.GetTags()[enricher.TagProcessType]; pt != "" {
+		return pt
+	}
+	if st := strings.Split(event.Event.GetLogMessage().GetSourceType(), "/"); len(st) >= 3 && st[0] == "APP" && st[1] == "PROC" {
+		return strings.ToLower(st[2])
+	}
+	return ""
+}
+
+// instanceGUID returns the GUID of the app instance that emitted the event,
+// if known.
+func instanceGUID(e *events.Envelope) string {
+	if id := e.GetHttpStartStop().GetInstanceId(); id != "" {
+		return id
+	}
+	return e.GetTags()[enricher.TagProcessInstanceID]
+}
+
+// mapped returns a function applying the measurement mapping to the result of
+// a converter.
+func (t *Transformer) mapped(e *events.Envelope) func(*Point, error) (*Point, error) {
+	return func(p *Point, err error) (*Point, error) {
+		if err != nil || len(t.mapping) == 0 {
+			return p, err
+		}
+		return t.mapping.apply(e, p)
+	}
+}
+
+// logLevel returns the level of an app log message, if enabled.
+func (t *Transformer) logLevel(e *events.LogMessage) string {
+	if !t.cfg.LogLevel || !isAppLog(e) {
+		return ""
+	}
+	return parseLogLevel(e.GetMessage(), t.cfg.LogLevelFields)
+}
+
+func isAppLog(e *events.LogMessage) bool {
+	return strings.HasPrefix(e.GetSourceType(), "APP") || strings.HasPrefix(e.GetSourceType(), "App")
+}
+
+// convertLogMessage converts a log message; level is the level of app logs,
+// if known.
+func convertLogMessage(e *events.LogMessage, meta enricher.AppMetadata, level string) (*Point, error) {
+	if isAppLog(e) {
+		return convertAppLogMessage(e, meta, level)
+	} else if strings.HasPrefix(e.GetSourceType(), "RTR") {
+		return convertRtrLogMessage(e, meta)
+	} else {
+		return nil, ErrEventDiscarded
+	}
+}
+
+func convertAppLogMessage(e *events.LogMessage, meta enricher.AppMetadata, level string) (*Point, error) {
+	return NewPoint(
+		"log",
+		withAppTags(meta, map[string]string{
+			"app":        meta.App,
+			"app_guid":   meta.AppGUID,
+			"space":      meta.Space,
+			"space_guid": meta.SpaceGUID,
+			"org":        meta.Org,
+			"org_guid":   meta.OrgGUID,
+			"foundation": meta.Foundation,
+			"instance":   e.GetSourceInstance(),
+			"type":       e.GetMessageType().String(),
+			"level":      level, // dropped if empty
+		}),
+		map[string]interface{}{
+			"count": 1, // Not needed but included for convenience.
+			"size":  len(e.GetMessage()),
+		},
+		time.Unix(0, e.GetTimestamp()),
+		KindEvent,
+	)
+}
+
+func convertRtrLogMessage(e *events.LogMessage, meta enricher.AppMetadata) (*Point, error) {
+	if l, err := parseAccessLog(string(e.GetMessage())); err == nil {
+		return convertAccessLog(l, e, meta)
+	}
+
+	return NewPoint(
+		"log",
+		withAppTags(meta, map[string]string{
+			"app":        meta.App,
+			"app_guid":   meta.AppGUID,
+			"space":      meta.Space,
+			"space_guid": meta.SpaceGUID,
+			"org":        meta.Org,
+			"org_guid":   meta.OrgGUID,
+			"foundation": meta.Foundation,
+			"instance":   e.GetSourceInstance(),
+			"type":       "RTR",
+		}),
+		map[string]interface{}{
+			"count": 1, // Not needed but included for convenience.
+			"size":  len(e.GetMessage()),
+		},
+		time.Unix(0, e.GetTimestamp()),
+		KindEvent,
+	)
+}
+
+func convertAccessLog(l *accessLog, e *events.LogMessage, meta enricher.AppMetadata) (*Point, error) {
+	fields := map[string]interface{}{
+		"count":          1, // Not needed but included for convenience.
+		"bytes_received": l.BytesReceived,
+		"bytes_sent":     l.BytesSent,
+	}
+	if v, ok := l.seconds("response_time"); ok {
+		fields["response_time"] = v
+	}
+	if v, ok := l.seconds("gorouter_time"); ok {
+		fields["gorouter_time"] = v
+	}
+
+	return NewPoint(
+		"router_request",
+		withAppTags(meta, map[string]string{
+			"app":         meta.App,
+			"app_guid":    meta.AppGUID,
+			"space":       meta.Space,
+			"space_guid":  meta.SpaceGUID,
+			"org":         meta.Org,
+			"org_guid":    meta.OrgGUID,
+			"foundation":  meta.Foundation,
+			"instance":    e.GetSourceInstance(),
+			"method":      l.Method,
+			"status_code": fmt.Sprint(l.StatusCode),
+			"host":        l.Host,
+		}),
+		fields,
+		time.Unix(0, e.GetTimestamp()),
+		KindEvent,
+	)
+}
+
+func convertContainerMetric(e *events.ContainerMetric, ts int64, meta enricher.AppMetadata) (*Point, error) {
+	return NewPoint(
+		"instance",
+		withAppTags(meta, map[string]string{
+			"app":        meta.App,
+			"app_guid":   meta.AppGUID,
+			"space":      meta.Space,
+			"space_guid": meta.SpaceGUID,
+			"org":        meta.Org,
+			"org_guid":   meta.OrgGUID,
+			"foundation": meta.Foundation,
+			"instance":   fmt.Sprint(e.GetInstanceIndex()),
+		}),
+		map[string]interface{}{
+			"cpu":          e.GetCpuPercentage(),
+			"memory":       int64(e.GetMemoryBytes()),
+			"disk":         int64(e.GetDiskBytes()),
+			"memory_quota": int64(e.GetMemoryBytesQuota()),
+			"disk_quota":   int64(e.GetDiskBytesQuota()),
+			"memory_pct":   float64(e.GetMemoryBytes()) / float64(e.GetMemoryBytesQuota()),
+			"disk_pct":     float64(e.GetDiskBytes()) / float64(e.GetDiskBytesQuota()),
+		},
+		time.Unix(0, ts),
+		KindGauge,
+	)
+}
+
+// convertPlatformEvent converts the metrics emitted by platform components
+// into "platform" points, identified by the component and the metric name.
+func (t *Transformer) convertPlatformEvent(e *events.Envelope, meta *PlatformMetadata) (*Point, error) {
+	return t.convertMetric(e, "platform", map[string]string{
+		"origin":     meta.Origin,
+		"deployment": meta.Deployment,
+		"job":        meta.Job,
+		"index":      meta.Index,
+		"ip":         meta.IP,
+	})
+}
+
+// convertAppMetric converts the custom metrics emitted by apps (e.g. via the
+// metric registrar) into "app_metric" points.
+func (t *Transformer) convertAppMetric(e *events.Envelope, meta enricher.AppMetadata) (*Point, error) {
+	return t.convertMetric(e, "app_metric", withAppTags(meta, map[string]string{
+		"app":        meta.App,
+		"app_guid":   meta.AppGUID,
+		"space":      meta.Space,
+		"space_guid": meta.SpaceGUID,
+		"org":        meta.Org,
+		"org_guid":   meta.OrgGUID,
+		"foundation": meta.Foundation,
+		"origin":     e.GetOrigin(),
+		"job":        e.GetJob(),
+		"index":      e.GetIndex(),
+	}))
+}
+
+// convertMetric converts a ValueMetric or CounterEvent into a point of the
+// specified measurement, adding the metric name as name tag, or into a point
+// of the measurement named after the metric if MetricNameAsMeasurement is set.
+func (t *Transformer) convertMetric(e *events.Envelope, measurement string, tags map[string]string) (*Point, error) {
+	var name string
+	var kind Kind
+	fields := make(map[string]interface{})
+	switch e.GetEventType() {
+	default:
+		return nil, ErrEventDiscarded
+
+	case events.Envelope_ValueMetric:
+		m := e.GetValueMetric()
+		name = m.GetName()
+		kind = KindGauge
+		tags["unit"] = m.GetUnit()
+		fields["value"] = m.GetValue()
+
+	case events.Envelope_CounterEvent:
+		c := e.GetCounterEvent()
+		name = c.GetName()
+		kind = KindCounter
+		fields["delta"] = int64(c.GetDelta())
+		fields["total"] = int64(c.GetTotal())
+	}
+
+	if t.cfg.MetricNameAsMeasurement && name != "" {
+		measurement = name
+	} else {
+		tags["name"] = name
+	}
+	return NewPoint(measurement, tags, fields, time.Unix(0, e.GetTimestamp()), kind)
+}
+
+// convertError converts the errors reported by loggregator components into
+// "error" points. The error message is not stored, to keep the cardinality low.
+func convertError(e *events.Envelope, meta *PlatformMetadata) (*Point, error) {
+	err := e.GetError()
+	return NewPoint(
+		"error",
+		map[string]string{
+			"origin":     meta.Origin,
+			"deployment": meta.Deployment,
+			"job":        meta.Job,
+			"index":      meta.Index,
+			"source":     err.GetSource(),
+			"code":       fmt.Sprint(err.GetCode()),
+		},
+		map[string]interface{}{
+			"count": 1,
+		},
+		time.Unix(0, e.GetTimestamp()),
+		KindEvent,
+	)
+}
+
+// withAppTags adds the additional tags of the app (if any) to the tags of a
+// point. Tags already set by the converter take precedence.
+func withAppTags(meta enricher.AppMetadata, tags map[string]string) map[string]string {
+	for k, v := range meta.Tags {
+		if _, found := tags[k]; !found {
+			tags[k] = v
+		}
+	}
+	return tags
+}
@@ -0,0 +1,489 @@
+package transformer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+func TestToPoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          string
+		appMeta      enricher.AppMetadata
+		wantPointNil bool
+		wantErr      bool
+	}{
+		{"APP log message", LogMsg, appMeta, false, false},
+		{"RTR log message", RTRLogMsg, appMeta, false, false},
+		{"Unknown log message EventDiscarded", UnknownLogMsg, appMeta, true, true},
+		{"container metrics", containerMetrics, appMeta, false, false},
+		{"httpStartStop", httpStartStop, appMeta, false, false},
+		{"None metadata EventDiscarded", LogMsg, enricher.AppMetadata{}, true, true},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(test.msg), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := ToPoint(&Envelope{Event: &e, Meta: test.appMeta})
+		if (err != nil) != test.wantErr {
+			t.Fatalf("TestToInfluxDBPoint %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+		if (p == nil) != test.wantPointNil {
+			t.Fatalf("TestToInfluxDBPoint %s: point = %v, wantNil %v", test.name, p, test.wantPointNil)
+		}
+	}
+
+}
+
+func TestConvertLogMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		logMsg string
+	}{
+		{"App log message", AppLogMsg},
+		{"APP log message", LogMsg},
+		{"RTR log message", RTRLogMsg},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(test.logMsg), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		point, err := convertLogMessage(e.GetLogMessage(), appMeta, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if point.Name != "log" {
+			t.Fatalf("TestConvertLogMessage %s: expected %v got %v", test.name, "log", point.Name)
+		}
+
+		tags := []string{"app", "app_guid", "space", "space_guid", "org", "org_guid", "instance"}
+		for _, key := range tags {
+			if point.Tags[key] != logPointTags[key] {
+				t.Fatalf("TestConvertLogMessage %s: expected %v got %v, key %v", test.name, logPointTags, point.Tags, key)
+			}
+		}
+
+		fields := []string{"count", "size"}
+		pointFields := point.Fields
+		for _, key := range fields {
+			if pointFields[key] != logPointFields[key] {
+				t.Fatalf("TestConvertLogMessage %s: expected %v got %v %v %v %v", test.name, key, pointFields[key], logPointFields[key], logPointFields, pointFields)
+			}
+		}
+
+		if point.Time.UnixNano() != logPointUnixNano {
+			t.Fatalf("TestConvertLogMessage %s: expected %v got %v", test.name, logPointUnixNano, point.Time.UnixNano())
+		}
+	}
+}
+
+func TestConvertUnknownLogMessage(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(UnknownLogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	point, err := convertLogMessage(e.GetLogMessage(), appMeta, "")
+	if err != ErrEventDiscarded || point != nil {
+		t.Fatalf("TestConvertUnknownLogMessage expected %v got %v", ErrEventDiscarded, err)
+	}
+}
+
+func TestConvertContainerMetric(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(containerMetrics), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	point, err := convertContainerMetric(e.GetContainerMetric(), e.GetTimestamp(), appMeta1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if point.Name != "instance" {
+		t.Fatalf("TestConvertContainerMetric expected %v got %v", "instance", point.Name)
+	}
+
+	tags := []string{"app", "app_guid", "space", "space_guid", "org", "org_guid", "instance"}
+	for _, key := range tags {
+		if point.Tags[key] != containerMetricsPointTags[key] {
+			t.Fatalf("TestConvertContainerMetric expected %v got %v", containerMetricsPointTags, point.Tags)
+		}
+	}
+
+	pointFields := point.Fields
+	fields := []string{"memory", "disk", "memory_quota", "disk_quota"}
+	for _, key := range fields {
+		if pointFields[key].(int64) != int64(containerMetricsPointFields[key].(int)) {
+			t.Fatalf("TestConvertContainerMetric expected %v got %v", containerMetricsPointFields, pointFields)
+		}
+	}
+	fields2 := []string{"cpu", "memory_pct", "disk_pct"}
+	for _, key := range fields2 {
+		if pointFields[key] != containerMetricsPointFields[key] {
+			t.Fatalf("TestConvertContainerMetric expected %v got %v", containerMetricsPointFields, pointFields)
+		}
+	}
+
+	if point.Time.UnixNano() != containerMetricsPointUnixNano {
+		t.Fatalf("TestConvertContainerMetric expected %v got %v", containerMetricsPointUnixNano, point.Time.UnixNano())
+	}
+}
+
+func TestConvertHttpStartStop(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(httpStartStop), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	point, err := convertHttpStartStop(e.GetHttpStartStop(), appMeta2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if point.Name != "http_request" {
+		t.Fatalf("TestConvertHttpStartStop expected %v got %v", "http_request", point.Name)
+	}
+
+	tags := []string{"app", "app_guid", "space", "space_guid", "org", "org_guid", "instance", "method", "status_code", "foundation", "peer_type"}
+	for _, key := range tags {
+		if point.Tags[key] != httpStartStopPointTags[key] {
+			t.Fatalf("TestConvertHttpStartStop expected %v got %v", httpStartStopPointTags, point.Tags)
+		}
+	}
+
+	pointFields := point.Fields
+	fields1 := []string{"duration"}
+	for _, key := range fields1 {
+		if pointFields[key].(float64) != float64(httpStartStopPointFields[key].(int)) {
+			t.Fatalf("TestConvertHttpStartStop expected %v got %v", httpStartStopPointFields, pointFields)
+		}
+	}
+	fields2 := []string{"response_size", "count"}
+	for _, key := range fields2 {
+		if pointFields[key].(int64) != int64(httpStartStopPointFields[key].(int)) {
+			t.Fatalf("TestConvertHttpStartStop expected %v got %v", httpStartStopPointFields, pointFields)
+		}
+	}
+}
+
+func TestConvertWithAppTags(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := appMeta
+	meta.Tags = map[string]string{"team": "payments", "app": "overridden"}
+	point, err := convertLogMessage(e.GetLogMessage(), meta, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if point.Tags["team"] != "payments" {
+		t.Fatalf("TestConvertWithAppTags: expected team tag, got %v", point.Tags)
+	}
+	if point.Tags["app"] != appMeta.App {
+		t.Fatalf("TestConvertWithAppTags: app tag must not be overridden, got %v", point.Tags)
+	}
+}
+
+func TestToInfluxDBPointSampleRate(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ToPoint(&Envelope{Event: &e, Meta: appMeta, SampleRate: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := p.Fields
+	if fields["sample_rate"] != 0.25 || fields["size"] != int64(12) {
+		t.Fatalf("TestToInfluxDBPointSampleRate: unexpected fields %v", fields)
+	}
+
+	p, err = ToPoint(&Envelope{Event: &e, Meta: appMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := p.Fields; fields["sample_rate"] != nil {
+		t.Fatalf("TestToInfluxDBPointSampleRate: unexpected sample_rate in %v", fields)
+	}
+}
+
+func TestInstanceTags(t *testing.T) {
+	workerLog := func(e *events.Envelope) {
+		st := "APP/PROC/WORKER"
+		e.LogMessage.SourceType = &st
+	}
+	v2Tags := func(e *events.Envelope) {
+		e.Tags = map[string]string{"process_type": "web", "process_instance_id": "b4a4c0b1-1ef8-4a52-6e5b-b0b2"}
+	}
+	workerMeta := appMeta
+	workerMeta.ProcessType = "worker"
+
+	tests := []struct {
+		name         string
+		msg          string
+		modify       func(*events.Envelope)
+		meta         enricher.AppMetadata
+		instanceGUID bool
+		want         map[string]string
+	}{
+		{"log without process", LogMsg, nil, appMeta, true, map[string]string{}},
+		{"log source type", LogMsg, workerLog, appMeta, true, map[string]string{"process_type": "worker"}},
+		{"RTR log", RTRLogMsg, v2Tags, appMeta, true, map[string]string{}},
+		{"http instance id", httpStartStop, nil, appMeta, true, map[string]string{"instance_guid": "d06a0894-aea7-4b88-4860-08fe"}},
+		{"http instance id disabled", httpStartStop, nil, appMeta, false, map[string]string{}},
+		{"v2 envelope tags", containerMetrics, v2Tags, appMeta, true, map[string]string{"process_type": "web", "instance_guid": "b4a4c0b1-1ef8-4a52-6e5b-b0b2"}},
+		{"v3 process metadata", containerMetrics, v2Tags, workerMeta, false, map[string]string{"process_type": "worker"}},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(test.msg), &e); err != nil {
+			t.Fatal(err)
+		}
+		if test.modify != nil {
+			test.modify(&e)
+		}
+
+		tr := NewTransformer(ConfigTransformer{InstanceGUID: test.instanceGUID}, nil, nil, nil, nil)
+		p, err := tr.ToPoint(&Envelope{Event: &e, Meta: test.meta})
+		if err != nil {
+			t.Fatalf("TestInstanceTags %s: %v", test.name, err)
+		}
+		got := map[string]string{}
+		for _, k := range []string{"process_type", "instance_guid"} {
+			if v, ok := p.Tags[k]; ok {
+				got[k] = v
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("TestInstanceTags %s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestFoundationTag(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(httpStartStop), &e); err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewTransformer(ConfigTransformer{FoundationTag: enabled}, nil, nil, nil, nil).ToPoint(&Envelope{Event: &e, Meta: appMeta2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if enabled {
+			want = appMeta2.Foundation
+		}
+		if got := p.Tags["foundation"]; got != want {
+			t.Fatalf("TestFoundationTag %v: expected %q, got %q", enabled, want, got)
+		}
+	}
+
+	// the tag is added before the mapping, and metrics embedded in logs can
+	// set their own when disabled
+	mapping := Mapping{{EventType: "HttpStartStop", RenameTags: map[string]string{"foundation": "cf"}}}
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(httpStartStop), &e); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewTransformer(ConfigTransformer{FoundationTag: true}, nil, nil, mapping, nil).ToPoint(&Envelope{Event: &e, Meta: appMeta2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tags["cf"] != appMeta2.Foundation {
+		t.Fatalf("TestFoundationTag: expected the renamed tag, got %v", p.Tags)
+	}
+
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	e.LogMessage.Message = []byte("METRIC:queue_length:42|g|#foundation:eu")
+	p, err = NewTransformer(ConfigTransformer{}, nil, NewLogMetrics(100, time.Hour, nil), nil, nil).ToPoint(&Envelope{Event: &e, Meta: appMeta2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tags["foundation"] != "eu" {
+		t.Fatalf("TestFoundationTag: expected the foundation tag of the metric, got %v", p.Tags)
+	}
+}
+
+func TestConvertPlatformEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		msg        string
+		wantTags   map[string]string
+		wantFields map[string]interface{}
+		wantErr    bool
+	}{
+		{"value metric", valueMetric, map[string]string{"name": "latency", "unit": "ms"}, map[string]interface{}{"value": 12.5}, false},
+		{"counter event", counterEvent, map[string]string{"name": "total_requests"}, map[string]interface{}{"delta": int64(5), "total": int64(1024)}, false},
+		{"log message EventDiscarded", LogMsg, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(test.msg), &e); err != nil {
+			t.Fatal(err)
+		}
+		env := &Envelope{Event: &e}
+		env.EnrichPlatform()
+
+		point, err := ToPoint(env)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("TestConvertPlatformEvent %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+		if test.wantErr {
+			continue
+		}
+
+		if point.Name != "platform" {
+			t.Fatalf("TestConvertPlatformEvent %s: expected platform got %v", test.name, point.Name)
+		}
+		tags := point.Tags
+		for _, want := range []map[string]string{platformPointTags, test.wantTags} {
+			for k, v := range want {
+				if tags[k] != v {
+					t.Fatalf("TestConvertPlatformEvent %s: expected tag %s=%v got %v", test.name, k, v, tags)
+				}
+			}
+		}
+		fields := point.Fields
+		if !reflect.DeepEqual(fields, test.wantFields) {
+			t.Fatalf("TestConvertPlatformEvent %s: expected fields %v got %v", test.name, test.wantFields, fields)
+		}
+	}
+}
+
+func TestConvertAppMetric(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             ConfigTransformer
+		wantMeasurement string
+		wantName        string
+	}{
+		{"name tag", ConfigTransformer{}, "app_metric", "queue_length"},
+		{"name as measurement", ConfigTransformer{MetricNameAsMeasurement: true}, "queue_length", ""},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(appValueMetric), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		point, err := NewTransformer(test.cfg, nil, nil, nil, nil).ToPoint(&Envelope{Event: &e, Meta: appMeta})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if point.Name != test.wantMeasurement {
+			t.Fatalf("TestConvertAppMetric %s: expected %v got %v", test.name, test.wantMeasurement, point.Name)
+		}
+
+		tags := point.Tags
+		wantTags := map[string]string{
+			"app":    "app",
+			"org":    "org",
+			"origin": "metric_registrar",
+			"job":    "cell",
+			"index":  "0",
+			"unit":   "jobs",
+			"name":   test.wantName,
+		}
+		for k, v := range wantTags {
+			if tags[k] != v {
+				t.Fatalf("TestConvertAppMetric %s: expected tag %s=%v got %v", test.name, k, v, tags)
+			}
+		}
+		if fields := point.Fields; fields["value"] != float64(7) {
+			t.Fatalf("TestConvertAppMetric %s: unexpected fields %v", test.name, fields)
+		}
+	}
+}
+
+func TestConvertError(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(errorEvent), &e); err != nil {
+		t.Fatal(err)
+	}
+	env := &Envelope{Event: &e}
+	env.EnrichPlatform()
+
+	point, err := ToPoint(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if point.Name != "error" {
+		t.Fatalf("TestConvertError: expected error got %v", point.Name)
+	}
+	wantTags := map[string]string{
+		"origin":     "loggregator",
+		"deployment": "cf",
+		"job":        "doppler",
+		"index":      "0",
+		"source":     "doppler",
+		"code":       "3",
+	}
+	if !reflect.DeepEqual(point.Tags, wantTags) {
+		t.Fatalf("TestConvertError: expected tags %v got %v", wantTags, point.Tags)
+	}
+	if fields := point.Fields; fields["count"] != int64(1) {
+		t.Fatalf("TestConvertError: unexpected fields %v", fields)
+	}
+}
+
+func TestConvertAccessLog(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(RTRAccessLogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	point, err := convertLogMessage(e.GetLogMessage(), appMeta, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if point.Name != "router_request" {
+		t.Fatalf("TestConvertAccessLog: expected router_request got %v", point.Name)
+	}
+
+	wantTags := map[string]string{
+		"app":         "app",
+		"instance":    "1",
+		"method":      "GET",
+		"status_code": "200",
+		"host":        "app.example.com",
+	}
+	for k, v := range wantTags {
+		if point.Tags[k] != v {
+			t.Fatalf("TestConvertAccessLog: expected tag %s=%v got %v", k, v, point.Tags)
+		}
+	}
+
+	wantFields := map[string]interface{}{
+		"count":          int64(1),
+		"bytes_received": int64(12),
+		"bytes_sent":     int64(1234),
+		"response_time":  0.012345,
+		"gorouter_time":  0.000123,
+	}
+	if fields := point.Fields; !reflect.DeepEqual(fields, wantFields) {
+		t.Fatalf("TestConvertAccessLog: expected fields %v got %v", wantFields, fields)
+	}
+}
@@ -0,0 +1,65 @@
+package transformer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// accessLog is a gorouter access log line, e.g.
+//
+//	app.example.com - [2019-01-02T03:04:05.678+0000] "GET /path HTTP/1.1" 200 0 1234 "-" "curl/7.58.0" "10.0.0.1:54321" "10.0.1.5:61001" x_forwarded_for:"1.2.3.4" x_forwarded_proto:"https" vcap_request_id:"b4d1f2a8-..." response_time:0.012 gorouter_time:0.000123 app_id:"..." app_index:"0"
+type accessLog struct {
+	Host          string
+	Method        string
+	Path          string
+	StatusCode    int
+	BytesReceived int64
+	BytesSent     int64
+	UserAgent     string
+	// the key:value pairs following the fixed fields (e.g. response_time)
+	Extra map[string]string
+}
+
+var (
+	accessLogRe   = regexp.MustCompile(`^(\S+) - \[[^\]]*\] "(\S+) (\S+)[^"]*" (\d{3}) (\d+) (\d+) "[^"]*" "([^"]*)" "[^"]*" "[^"]*"(.*)$`)
+	accessLogKVRe = regexp.MustCompile(`(\w+):(?:"([^"]*)"|(\S+))`)
+)
+
+// parseAccessLog parses a gorouter access log line.
+func parseAccessLog(line string) (*accessLog, error) {
+	m := accessLogRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return nil, errors.New("not a gorouter access log line")
+	}
+
+	status, _ := strconv.Atoi(m[4])
+	received, _ := strconv.ParseInt(m[5], 10, 64)
+	sent, _ := strconv.ParseInt(m[6], 10, 64)
+	l := &accessLog{
+		Host:          m[1],
+		Method:        m[2],
+		Path:          m[3],
+		StatusCode:    status,
+		BytesReceived: received,
+		BytesSent:     sent,
+		UserAgent:     m[7],
+		Extra:         make(map[string]string),
+	}
+	for _, kv := range accessLogKVRe.FindAllStringSubmatch(m[8], -1) {
+		if kv[2] != "" {
+			l.Extra[kv[1]] = kv[2]
+		} else {
+			l.Extra[kv[1]] = kv[3]
+		}
+	}
+	return l, nil
+}
+
+// seconds returns the value of an extra key holding a duration in seconds.
+func (l *accessLog) seconds(key string) (float64, bool) {
+	v, err := strconv.ParseFloat(l.Extra[key], 64)
+	return v, err == nil
+}
@@ -0,0 +1,55 @@
+package transformer
+
+import (
+	"reflect"
+	"testing"
+)
+
+const accessLogLine = `app.example.com - [2019-01-02T03:04:05.678+0000] "GET /v2/info?x=1 HTTP/1.1" 200 12 1234 "-" "curl/7.58.0" "10.0.0.1:54321" "10.0.1.5:61001" x_forwarded_for:"1.2.3.4, 10.0.0.1" x_forwarded_proto:"https" vcap_request_id:"b4d1f2a8-1c2d-4e5f-8a9b-0c1d2e3f4a5b" response_time:0.012345 gorouter_time:0.000123 app_id:"00000000-0000-0000-0000-000000000003" app_index:"1"`
+
+func TestParseAccessLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    *accessLog
+		wantErr bool
+	}{
+		{"access log", accessLogLine, &accessLog{
+			Host:          "app.example.com",
+			Method:        "GET",
+			Path:          "/v2/info?x=1",
+			StatusCode:    200,
+			BytesReceived: 12,
+			BytesSent:     1234,
+			UserAgent:     "curl/7.58.0",
+			Extra: map[string]string{
+				"x_forwarded_for":   "1.2.3.4, 10.0.0.1",
+				"x_forwarded_proto": "https",
+				"vcap_request_id":   "b4d1f2a8-1c2d-4e5f-8a9b-0c1d2e3f4a5b",
+				"response_time":     "0.012345",
+				"gorouter_time":     "0.000123",
+				"app_id":            "00000000-0000-0000-0000-000000000003",
+				"app_index":         "1",
+			},
+		}, false},
+		{"no extra fields", `app.example.com - [2019-01-02T03:04:05.678+0000] "POST / HTTP/1.1" 502 0 67 "-" "" "10.0.0.1:54321" "-"`, &accessLog{
+			Host:       "app.example.com",
+			Method:     "POST",
+			Path:       "/",
+			StatusCode: 502,
+			BytesSent:  67,
+			Extra:      map[string]string{},
+		}, false},
+		{"not an access log", "hello world", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseAccessLog(test.line)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("TestParseAccessLog %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("TestParseAccessLog %s: expected %+v got %+v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package transformer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// normalized log levels
+var logLevels = map[string]string{
+	"trace":       "debug",
+	"debug":       "debug",
+	"dbg":         "debug",
+	"info":        "info",
+	"information": "info",
+	"notice":      "info",
+	"warn":        "warn",
+	"warning":     "warn",
+	"error":       "error",
+	"err":         "error",
+	"fatal":       "fatal",
+	"critical":    "fatal",
+	"crit":        "fatal",
+	"panic":       "fatal",
+	"alert":       "fatal",
+	"emergency":   "fatal",
+}
+
+// parseLogLevel returns the normalized level of a JSON log line, read from
+// the first of the specified fields that is present. Numeric levels follow
+// the bunyan/pino convention (10 trace, 20 debug, 30 info, 40 warn, 50 error,
+// 60 fatal). It returns an empty string if the line is not a JSON object or
+// the level is unknown.
+func parseLogLevel(msg []byte, fields []string) string {
+	msg = bytes.TrimSpace(msg)
+	if len(msg) == 0 || msg[0] != '{' {
+		return ""
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal(msg, &line); err != nil {
+		return ""
+	}
+
+	for _, f := range fields {
+		switch v := line[f].(type) {
+		case string:
+			return logLevels[strings.ToLower(strings.TrimSpace(v))]
+		case float64:
+			switch {
+			case v < 30:
+				return "debug"
+			case v < 40:
+				return "info"
+			case v < 50:
+				return "warn"
+			case v < 60:
+				return "error"
+			default:
+				return "fatal"
+			}
+		}
+	}
+	return ""
+}
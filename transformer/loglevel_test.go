@@ -0,0 +1,61 @@
+package transformer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	fields := []string{"level", "severity"}
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{"level", `{"level":"INFO","msg":"started"}`, "info"},
+		{"severity", `{"severity":"warning"}`, "warn"},
+		{"first field wins", `{"level":"error","severity":"debug"}`, "error"},
+		{"numeric level", `{"level":50}`, "error"},
+		{"fatal alias", ` {"severity":"CRITICAL"}` + "\n", "fatal"},
+		{"unknown level", `{"level":"verbose"}`, ""},
+		{"no level", `{"msg":"started"}`, ""},
+		{"not JSON", `level=info msg=started`, ""},
+		{"invalid JSON", `{"level":"info"`, ""},
+	}
+
+	for _, test := range tests {
+		if got := parseLogLevel([]byte(test.msg), fields); got != test.want {
+			t.Fatalf("TestParseLogLevel %s: expected %q got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestTransformerLogLevel(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	e.LogMessage.Message = []byte(`{"lvl":"error","msg":"boom"}`)
+
+	tests := []struct {
+		name string
+		cfg  ConfigTransformer
+		want string
+	}{
+		{"disabled", ConfigTransformer{LogLevelFields: []string{"lvl"}}, ""},
+		{"enabled", ConfigTransformer{LogLevel: true, LogLevelFields: []string{"lvl"}}, "error"},
+		{"other fields", ConfigTransformer{LogLevel: true, LogLevelFields: []string{"level"}}, ""},
+	}
+
+	for _, test := range tests {
+		point, err := NewTransformer(test.cfg, nil, nil, nil, nil).ToPoint(&Envelope{Event: &e, Meta: appMeta})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := point.Tags["level"]; got != test.want {
+			t.Fatalf("TestTransformerLogLevel %s: expected %q got %q", test.name, test.want, got)
+		}
+	}
+}
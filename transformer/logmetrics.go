@@ -0,0 +1,241 @@
+package transformer
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pkg/errors"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+// LogMetricPrefix marks the app log lines containing a metric, in the format
+//
+//	METRIC:<name>:<value>|<type>[|#<tag>:<value>,...]
+//
+// where type is g for gauges and c for counters, e.g.
+//
+//	METRIC:queue_length:42|g|#queue:emails
+const LogMetricPrefix = "METRIC:"
+
+// logMetric is a metric parsed from a log line
+type logMetric struct {
+	Name    string
+	Value   float64
+	Counter bool
+	Tags    map[string]string
+}
+
+var logMetricNameRe = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// logMetricsPruneSteps is how many times per window the series of an app over
+// the limit can be expired, instead of at every new series.
+const logMetricsPruneSteps = 60
+
+// parseLogMetric parses a log line containing a metric. It returns false if
+// the line is not a valid metric.
+func parseLogMetric(line string) (*logMetric, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, LogMetricPrefix) {
+		return nil, false
+	}
+	parts := strings.Split(line[len(LogMetricPrefix):], "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, false
+	}
+
+	i := strings.LastIndex(parts[0], ":")
+	if i < 0 || !logMetricNameRe.MatchString(parts[0][:i]) {
+		return nil, false
+	}
+	value, err := strconv.ParseFloat(parts[0][i+1:], 64)
+	if err != nil {
+		return nil, false
+	}
+	m := &logMetric{Name: parts[0][:i], Value: value}
+
+	switch parts[1] {
+	case "g":
+	case "c":
+		m.Counter = true
+	default:
+		return nil, false
+	}
+
+	if len(parts) == 3 {
+		if !strings.HasPrefix(parts[2], "#") {
+			return nil, false
+		}
+		m.Tags = make(map[string]string)
+		for _, tag := range strings.Split(parts[2][1:], ",") {
+			kv := strings.SplitN(tag, ":", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return nil, false
+			}
+			m.Tags[kv[0]] = kv[1]
+		}
+	}
+	return m, true
+}
+
+// series identifies the series of the metric within an app
+func (m *logMetric) series() string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := m.Name
+	for _, k := range keys {
+		s += "," + k + "=" + m.Tags[k]
+	}
+	return s
+}
+
+// LogMetricCallback is called for every metric found in the app logs; dropped
+// is true if it exceeded the cardinality limit of the app.
+type LogMetricCallback func(dropped bool)
+
+// LogMetrics converts the metrics embedded by apps in their logs into
+// "app_metric" points. The number of distinct series (metric name and tags)
+// of each app seen in a sliding window is limited, to protect the database
+// from runaway cardinality.
+type LogMetrics struct {
+	maxSeries int
+	window    time.Duration
+	cb        LogMetricCallback
+	now       func() time.Time
+
+	l         sync.Mutex
+	series    map[string]*appSeries // app GUID -> series
+	lastPrune time.Time
+}
+
+type appSeries struct {
+	series    map[string]time.Time // series -> last seen
+	lastPrune time.Time
+}
+
+// NewLogMetrics creates a LogMetrics accepting up to maxSeries series per
+// app seen in the window. The callback, if not nil, is called for every
+// metric found.
+func NewLogMetrics(maxSeries int, window time.Duration, cb LogMetricCallback) *LogMetrics {
+	return &LogMetrics{
+		maxSeries: maxSeries,
+		window:    window,
+		cb:        cb,
+		now:       time.Now,
+		series:    make(map[string]*appSeries),
+	}
+}
+
+// accept returns true if the series is known or there is room for it. The
+// series not seen in the window are forgotten.
+func (lm *LogMetrics) accept(appGUID string, m *logMetric) bool {
+	s := m.series()
+
+	lm.l.Lock()
+	defer lm.l.Unlock()
+
+	now := lm.now()
+	if now.Sub(lm.lastPrune) >= lm.window {
+		lm.pruneWithLock(now)
+	}
+
+	as, found := lm.series[appGUID]
+	if !found {
+		as = &appSeries{series: make(map[string]time.Time)}
+		lm.series[appGUID] = as
+	}
+	if _, found := as.series[s]; found || len(as.series) < lm.maxSeries {
+		as.series[s] = now
+		return true
+	}
+	if now.Sub(as.lastPrune) >= lm.window/logMetricsPruneSteps {
+		as.lastPrune = now
+		as.prune(now.Add(-lm.window))
+	}
+	if len(as.series) < lm.maxSeries {
+		as.series[s] = now
+		return true
+	}
+	return false
+}
+
+// pruneWithLock forgets the series not seen in the window, and the apps
+// without series.
+func (lm *LogMetrics) pruneWithLock(now time.Time) {
+	lm.lastPrune = now
+	expire := now.Add(-lm.window)
+	for app, as := range lm.series {
+		as.prune(expire)
+		if len(as.series) == 0 {
+			delete(lm.series, app)
+		}
+	}
+}
+
+func (as *appSeries) prune(expire time.Time) {
+	for s, t := range as.series {
+		if t.Before(expire) {
+			delete(as.series, s)
+		}
+	}
+}
+
+// convert returns the point of the metric contained in the log message. It
+// returns false if the log message does not contain a metric. The name of
+// the metric is always written in the name tag, so that apps can't write to
+// other measurements.
+func (lm *LogMetrics) convert(e *events.LogMessage, meta enricher.AppMetadata) (*Point, bool, error) {
+	m, ok := parseLogMetric(string(e.GetMessage()))
+	if !ok {
+		return nil, false, nil
+	}
+
+	accepted := lm.accept(meta.AppGUID, m)
+	if lm.cb != nil {
+		lm.cb(!accepted)
+	}
+	if !accepted {
+		return nil, true, ErrEventDiscarded
+	}
+
+	tags := withAppTags(meta, map[string]string{
+		"app":        meta.App,
+		"app_guid":   meta.AppGUID,
+		"space":      meta.Space,
+		"space_guid": meta.SpaceGUID,
+		"org":        meta.Org,
+		"org_guid":   meta.OrgGUID,
+		"foundation": meta.Foundation,
+		"instance":   e.GetSourceInstance(),
+		"name":       m.Name,
+	})
+	// tags set by the app never override the app metadata
+	for k, v := range m.Tags {
+		if tv, found := tags[k]; !found || tv == "" {
+			tags[k] = v
+		}
+	}
+
+	fields := map[string]interface{}{}
+	kind := KindGauge
+	if m.Counter {
+		kind = KindCounter
+		fields["delta"] = m.Value
+	} else {
+		fields["value"] = m.Value
+	}
+
+	p, err := NewPoint("app_metric", tags, fields, time.Unix(0, e.GetTimestamp()), kind)
+	if err != nil {
+		return nil, true, errors.Wrap(err, "converting log metric")
+	}
+	return p, true, nil
+}
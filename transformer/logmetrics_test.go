@@ -0,0 +1,137 @@
+package transformer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func TestParseLogMetric(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   *logMetric
+		wantOk bool
+	}{
+		{"gauge", "METRIC:queue_length:42|g\n", &logMetric{Name: "queue_length", Value: 42}, true},
+		{"counter with tags", "METRIC:jobs.done:3|c|#queue:emails,prio:high", &logMetric{Name: "jobs.done", Value: 3, Counter: true, Tags: map[string]string{"queue": "emails", "prio": "high"}}, true},
+		{"negative float", "METRIC:temp:-1.5|g", &logMetric{Name: "temp", Value: -1.5}, true},
+		{"regular log", "queue_length:42|g", nil, false},
+		{"invalid value", "METRIC:queue_length:many|g", nil, false},
+		{"invalid type", "METRIC:queue_length:42|h", nil, false},
+		{"invalid name", "METRIC:queue length:42|g", nil, false},
+		{"invalid tags", "METRIC:queue_length:42|g|#queue", nil, false},
+		{"too many parts", "METRIC:queue_length:42|g|#a:b|x", nil, false},
+	}
+
+	for _, test := range tests {
+		got, ok := parseLogMetric(test.line)
+		if ok != test.wantOk || !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("TestParseLogMetric %s: expected %+v, %v got %+v, %v", test.name, test.want, test.wantOk, got, ok)
+		}
+	}
+}
+
+func TestTransformerLogMetrics(t *testing.T) {
+	var dropped, total int
+	lm := NewLogMetrics(2, time.Hour, func(d bool) {
+		total++
+		if d {
+			dropped++
+		}
+	})
+	tr := NewTransformer(ConfigTransformer{}, nil, lm, nil, nil)
+
+	tests := []struct {
+		name            string
+		msg             string
+		wantMeasurement string
+		wantErr         error
+	}{
+		{"first series", "METRIC:queue_length:42|g|#queue:emails,app:other", "app_metric", nil},
+		{"second series", "METRIC:queue_length:7|g|#queue:sms", "app_metric", nil},
+		{"known series", "METRIC:queue_length:40|g|#queue:emails,app:other", "app_metric", nil},
+		{"over the limit", "METRIC:queue_length:1|g|#queue:push", "", ErrEventDiscarded},
+		{"regular log", "hello world", "log", nil},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+			t.Fatal(err)
+		}
+		e.LogMessage.Message = []byte(test.msg)
+
+		p, err := tr.ToPoint(&Envelope{Event: &e, Meta: appMeta})
+		if err != test.wantErr {
+			t.Fatalf("TestTransformerLogMetrics %s: expected error %v got %v", test.name, test.wantErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if p.Name != test.wantMeasurement {
+			t.Fatalf("TestTransformerLogMetrics %s: expected %v got %v", test.name, test.wantMeasurement, p.Name)
+		}
+		if p.Name == "app_metric" && (p.Tags["app"] != "app" || p.Tags["name"] != "queue_length" || p.Tags["queue"] == "") {
+			t.Fatalf("TestTransformerLogMetrics %s: unexpected tags %v", test.name, p.Tags)
+		}
+	}
+
+	if total != 4 || dropped != 1 {
+		t.Fatalf("TestTransformerLogMetrics: expected 4 metrics and 1 dropped, got %d and %d", total, dropped)
+	}
+}
+
+func TestLogMetricsWindow(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	lm := NewLogMetrics(1, time.Hour, nil)
+	lm.now = func() time.Time { return now }
+
+	for _, test := range []struct {
+		after time.Duration
+		app   string
+		name  string
+		want  bool
+	}{
+		{0, "app", "a", true},
+		{30 * time.Minute, "app", "b", false},
+		{30 * time.Minute, "app", "a", true},
+		// a was last seen an hour ago: b takes its place
+		{time.Hour + time.Second, "app", "b", true},
+		{time.Minute, "app", "a", false},
+	} {
+		now = now.Add(test.after)
+		if got := lm.accept(test.app, &logMetric{Name: test.name}); got != test.want {
+			t.Fatalf("TestLogMetricsWindow %s after %v: expected %v, got %v", test.name, test.after, test.want, got)
+		}
+	}
+
+	// apps without series are forgotten
+	lm.accept("other", &logMetric{Name: "a"})
+	now = now.Add(2 * time.Hour)
+	lm.accept("app", &logMetric{Name: "a"})
+	if _, found := lm.series["other"]; found || len(lm.series) != 1 {
+		t.Fatalf("TestLogMetricsWindow: expected only app to be tracked, got %v", lm.series)
+	}
+}
+
+func TestLogMetricsBuiltinMeasurement(t *testing.T) {
+	tr := NewTransformer(ConfigTransformer{MetricNameAsMeasurement: true}, nil, NewLogMetrics(100, time.Hour, nil), nil, nil)
+
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	e.LogMessage.Message = []byte("METRIC:http_request:1|c|#name:log")
+
+	p, err := tr.ToPoint(&Envelope{Event: &e, Meta: appMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "app_metric" || p.Tags["name"] != "http_request" {
+		t.Fatalf("TestLogMetricsBuiltinMeasurement: expected an app_metric point named http_request, got %v", p)
+	}
+}
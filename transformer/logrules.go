@@ -0,0 +1,177 @@
+package transformer
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pkg/errors"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+// LogRule converts the log messages matching a regular expression into points
+// of the Metric measurement, e.g.
+//
+//	{"name": "oom", "regex": "OutOfMemoryError", "source_types": ["APP"], "metric": "log_oom"}
+//	{"name": "slow_query", "regex": "slow query \\((?P<ms>\\d+)ms\\) on (?P<table>\\w+)",
+//	 "metric": "log_slow_query", "tags": {"table": "table"}, "fields": {"ms": "duration_ms"}}
+//
+// Tags and Fields map the capture groups (by name or index) of the regular
+// expression to the tags and numeric fields of the points.
+type LogRule struct {
+	Name        string            `json:"name"`
+	Regex       string            `json:"regex"`
+	SourceTypes []string          `json:"source_types"` // prefixes of the source types; empty matches any
+	Metric      string            `json:"metric"`
+	Tags        map[string]string `json:"tags"`
+	Fields      map[string]string `json:"fields"`
+
+	re *regexp.Regexp
+}
+
+func (r *LogRule) compile() error {
+	if r.Metric == "" {
+		return errors.New("missing metric")
+	}
+	if r.Name == "" {
+		r.Name = r.Metric
+	}
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return err
+	}
+	r.re = re
+
+	for _, groups := range []map[string]string{r.Tags, r.Fields} {
+		for g := range groups {
+			if r.group(g) < 0 {
+				return errors.Errorf("unknown capture group %q", g)
+			}
+		}
+	}
+	return nil
+}
+
+// group returns the index of the capture group, identified by name or index,
+// or -1 if it does not exist.
+func (r *LogRule) group(g string) int {
+	if i, err := strconv.Atoi(g); err == nil {
+		if i > 0 && i <= r.re.NumSubexp() {
+			return i
+		}
+		return -1
+	}
+	for i, name := range r.re.SubexpNames() {
+		if name == g && i > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *LogRule) matchesSourceType(sourceType string) bool {
+	if len(r.SourceTypes) == 0 {
+		return true
+	}
+	for _, st := range r.SourceTypes {
+		if strings.HasPrefix(sourceType, st) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleCallback is called with the name of a rule every time it matches.
+type RuleCallback func(rule string)
+
+// LogRules is an ordered set of LogRules. A log message is matched against
+// all the rules, so it can produce multiple points.
+type LogRules struct {
+	rules []LogRule
+	cb    RuleCallback
+}
+
+// NewLogRules compiles the rules. The callback, if not nil, is called every
+// time a rule matches.
+func NewLogRules(rules []LogRule, cb RuleCallback) (*LogRules, error) {
+	names := make(map[string]bool, len(rules))
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, errors.Wrapf(err, "compiling log rule %d", i)
+		}
+		if names[rules[i].Name] {
+			return nil, errors.Errorf("duplicated log rule %q", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+	return &LogRules{rules: rules, cb: cb}, nil
+}
+
+// LoadLogRules loads the rules from a JSON file containing a list of LogRules.
+func LoadLogRules(path string, cb RuleCallback) (*LogRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading log rules")
+	}
+	defer f.Close()
+
+	var rules []LogRule
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, errors.Wrapf(err, "parsing log rules %s", path)
+	}
+	return NewLogRules(rules, cb)
+}
+
+// convert returns the points of all the rules matching the log message.
+func (r *LogRules) convert(e *events.LogMessage, meta enricher.AppMetadata) ([]*Point, error) {
+	var ps []*Point
+	msg := string(e.GetMessage())
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.matchesSourceType(e.GetSourceType()) {
+			continue
+		}
+		m := rule.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		if r.cb != nil {
+			r.cb(rule.Name)
+		}
+
+		tags := withAppTags(meta, map[string]string{
+			"app":         meta.App,
+			"app_guid":    meta.AppGUID,
+			"space":       meta.Space,
+			"space_guid":  meta.SpaceGUID,
+			"org":         meta.Org,
+			"org_guid":    meta.OrgGUID,
+			"foundation":  meta.Foundation,
+			"instance":    e.GetSourceInstance(),
+			"source_type": e.GetSourceType(),
+		})
+		for g, tag := range rule.Tags {
+			tags[tag] = m[rule.group(g)]
+		}
+		fields := map[string]interface{}{
+			"count": 1,
+		}
+		for g, field := range rule.Fields {
+			// skip values that are not numbers rather than failing the batch
+			if v, err := strconv.ParseFloat(m[rule.group(g)], 64); err == nil {
+				fields[field] = v
+			}
+		}
+
+		p, err := NewPoint(rule.Metric, tags, fields, time.Unix(0, e.GetTimestamp()), KindEvent)
+		if err != nil {
+			return nil, errors.Wrapf(err, "converting log rule %q", rule.Name)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
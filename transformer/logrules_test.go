@@ -0,0 +1,100 @@
+package transformer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func TestNewLogRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []LogRule
+		wantErr bool
+	}{
+		{"valid", []LogRule{{Regex: "OutOfMemoryError", Metric: "log_oom"}, {Name: "slow", Regex: `(?P<ms>\d+)ms`, Metric: "log_slow", Fields: map[string]string{"ms": "ms", "1": "ms1"}}}, false},
+		{"missing metric", []LogRule{{Regex: "panic:"}}, true},
+		{"invalid regex", []LogRule{{Regex: "(", Metric: "log_broken"}}, true},
+		{"unknown group name", []LogRule{{Regex: `(?P<ms>\d+)ms`, Metric: "log_slow", Tags: map[string]string{"sec": "sec"}}}, true},
+		{"unknown group index", []LogRule{{Regex: `(\d+)ms`, Metric: "log_slow", Tags: map[string]string{"2": "sec"}}}, true},
+		{"duplicated name", []LogRule{{Regex: "a", Metric: "log_a"}, {Regex: "b", Metric: "log_a"}}, true},
+	}
+
+	for _, test := range tests {
+		if _, err := NewLogRules(test.rules, nil); (err != nil) != test.wantErr {
+			t.Fatalf("TestNewLogRules %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestLoadLogRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logrules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(path, []byte(`[{"name": "oom", "regex": "OutOfMemoryError", "source_types": ["APP"], "metric": "log_oom"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadLogRules(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.rules) != 1 || rules.rules[0].Name != "oom" || rules.rules[0].SourceTypes[0] != "APP" {
+		t.Fatalf("TestLoadLogRules: unexpected rules %+v", rules.rules)
+	}
+
+	if _, err := LoadLogRules(filepath.Join(dir, "missing.json"), nil); err == nil {
+		t.Fatal("TestLoadLogRules: expected error, got nil")
+	}
+}
+
+func TestToInfluxDBPointsLogRules(t *testing.T) {
+	matches := make(map[string]int)
+	rules, err := NewLogRules([]LogRule{
+		{Name: "slow", Regex: `slow query \((?P<ms>\d+)ms\) on (?P<table>\w+)`, Metric: "log_slow_query", Tags: map[string]string{"table": "table"}, Fields: map[string]string{"ms": "duration_ms"}},
+		{Name: "query", Regex: `query`, SourceTypes: []string{"APP"}, Metric: "log_query"},
+		{Name: "rtr", Regex: `query`, SourceTypes: []string{"RTR"}, Metric: "log_rtr"},
+		{Name: "oom", Regex: `OutOfMemoryError`, Metric: "log_oom"},
+	}, func(rule string) {
+		matches[rule]++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	e.LogMessage.Message = []byte("slow query (1500ms) on users")
+
+	ps, err := NewTransformer(ConfigTransformer{}, rules, nil, nil, nil).ToPoints(&Envelope{Event: &e, Meta: appMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name)
+	}
+	if want := []string{"log", "log_slow_query", "log_query"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("TestToInfluxDBPointsLogRules: expected points %v got %v", want, names)
+	}
+	if tags := ps[1].Tags; tags["table"] != "users" || tags["app"] != "app" || tags["source_type"] != "APP" {
+		t.Fatalf("TestToInfluxDBPointsLogRules: unexpected tags %v", tags)
+	}
+	if fields := ps[1].Fields; fields["duration_ms"] != float64(1500) || fields["count"] != int64(1) {
+		t.Fatalf("TestToInfluxDBPointsLogRules: unexpected fields %v", fields)
+	}
+	if want := map[string]int{"slow": 1, "query": 1}; !reflect.DeepEqual(matches, want) {
+		t.Fatalf("TestToInfluxDBPointsLogRules: expected matches %v got %v", want, matches)
+	}
+}
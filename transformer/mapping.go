@@ -0,0 +1,212 @@
+package transformer
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pkg/errors"
+)
+
+// ErrEmptyPoint is returned when the mapping leaves a point without fields.
+var ErrEmptyPoint = errors.New("point without fields after the mapping")
+
+// MeasurementMapping changes the schema of the points converted from the
+// envelopes of an event type (and, for log messages, of a source type), e.g.
+//
+//	{"event_type": "HttpStartStop", "measurement": "http",
+//	 "rename_tags": {"status_code": "status"}, "drop_tags": ["space_guid", "org_guid"],
+//	 "add_tags": {"source": "cf"}, "fields": ["duration", "response_size"]}
+//	{"event_type": "LogMessage", "source_type": "RTR", "drop": true}
+type MeasurementMapping struct {
+	EventType    string            `json:"event_type"`  // e.g. HttpStartStop, LogMessage, ContainerMetric
+	SourceType   string            `json:"source_type"` // prefix of the log source type; empty matches any
+	Measurement  string            `json:"measurement"` // if empty, the measurement is not renamed
+	RenameTags   map[string]string `json:"rename_tags"`
+	DropTags     []string          `json:"drop_tags"`
+	AddTags      map[string]string `json:"add_tags"` // tags with a constant value
+	Fields       []string          `json:"fields"`   // fields emitted (before renaming); empty emits all
+	RenameFields map[string]string `json:"rename_fields"`
+	DropFields   []string          `json:"drop_fields"`
+	Drop         bool              `json:"drop"` // drop the points entirely
+}
+
+// Mapping is an ordered list of MeasurementMappings: the first one matching
+// an envelope is applied to its point. The default, empty, Mapping keeps the
+// schema of the converters (see the README for the default measurements, and
+// docs/mapping.json for the equivalent mapping file).
+type Mapping []MeasurementMapping
+
+// LoadMapping loads the mapping from a JSON file containing a list of
+// MeasurementMappings.
+func LoadMapping(path string) (Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading measurement mapping")
+	}
+	defer f.Close()
+
+	var m Mapping
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, errors.Wrapf(err, "parsing measurement mapping %s", path)
+	}
+	for i, mm := range m {
+		if _, found := events.Envelope_EventType_value[mm.EventType]; !found {
+			return nil, errors.Errorf("unknown event type %q in measurement mapping %d", mm.EventType, i)
+		}
+	}
+	return m, nil
+}
+
+func (m Mapping) match(e *events.Envelope) *MeasurementMapping {
+	for i := range m {
+		mm := &m[i]
+		if mm.EventType != e.GetEventType().String() {
+			continue
+		}
+		if mm.SourceType != "" && !strings.HasPrefix(e.GetLogMessage().GetSourceType(), mm.SourceType) {
+			continue
+		}
+		return mm
+	}
+	return nil
+}
+
+// apply applies the first mapping matching the envelope to its point.
+func (m Mapping) apply(e *events.Envelope, p *Point) (*Point, error) {
+	mm := m.match(e)
+	if mm == nil {
+		return p, nil
+	}
+	if mm.Drop {
+		return nil, ErrEventDiscarded
+	}
+
+	name := p.Name
+	if mm.Measurement != "" {
+		name = mm.Measurement
+	}
+	tags := make(map[string]string, len(p.Tags))
+	for k, v := range p.Tags {
+		if k, keep := mapKey(k, mm.RenameTags, mm.DropTags); keep {
+			tags[k] = v
+		}
+	}
+	for k, v := range mm.AddTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{}, len(p.Fields))
+	for k, v := range p.Fields {
+		if len(mm.Fields) > 0 && !contains(mm.Fields, k) {
+			continue
+		}
+		if k, keep := mapKey(k, mm.RenameFields, mm.DropFields); keep {
+			fields[k] = v
+		}
+	}
+	if len(fields) == 0 {
+		return nil, ErrEmptyPoint
+	}
+	return NewPoint(name, tags, fields, p.Time, p.Kind)
+}
+
+// mapKey returns the new name of a tag or field, and false if it is dropped.
+func mapKey(k string, rename map[string]string, drop []string) (string, bool) {
+	if contains(drop, k) {
+		return "", false
+	}
+	if n, found := rename[k]; found {
+		return n, true
+	}
+	return k, true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// logMeasurements are the default measurements of the log messages of each
+// source type.
+var logMeasurements = map[string][]string{
+	"APP":  {"log"},
+	"RTR":  {"router_request", "log"},
+	"API":  {"app_event"},
+	"CELL": {"app_event"},
+	"STG":  {"app_event"},
+	"LGR":  {"app_event"},
+	"SSH":  {"app_event"},
+}
+
+// defaultMeasurements returns the measurements of the points the mapping
+// can match, before renaming.
+func (mm MeasurementMapping) defaultMeasurements() []string {
+	switch mm.EventType {
+	case "HttpStartStop":
+		return []string{"http_request"}
+	case "ContainerMetric":
+		return []string{"instance"}
+	case "ValueMetric", "CounterEvent":
+		return []string{"app_metric", "platform"}
+	case "Error":
+		return []string{"error"}
+	case "LogMessage":
+		var ms []string
+		for source, names := range logMeasurements {
+			if strings.HasPrefix(source, mm.SourceType) || strings.HasPrefix(mm.SourceType, source) {
+				ms = append(ms, names...)
+			}
+		}
+		return ms
+	}
+	return nil
+}
+
+// MappingUsage lists the names that the rest of the configuration relies on,
+// and that the mapping must not change.
+type MappingUsage struct {
+	Measurements []string // e.g. the measurements of the collision strategies
+	Aggregated   []string // measurements aggregated into rollups
+	Fields       []string // fields summarized in the rollups
+	Tags         []string // e.g. the peer_type tag used by the correlation
+}
+
+// Validate returns an error if the mapping renames one of the measurements,
+// drops or renames one of the tags, or drops or renames one of the fields of
+// the aggregated measurements in use. A measurement or a field can be renamed
+// to another one in use.
+func (m Mapping) Validate(u MappingUsage) error {
+	measurements := append(append([]string{}, u.Measurements...), u.Aggregated...)
+	for i, mm := range m {
+		if mm.Drop {
+			continue
+		}
+		aggregated := contains(u.Aggregated, mm.Measurement)
+		for _, name := range mm.defaultMeasurements() {
+			if mm.Measurement == "" {
+				aggregated = aggregated || contains(u.Aggregated, name)
+			} else if contains(measurements, name) && !contains(measurements, mm.Measurement) {
+				return errors.Errorf("measurement mapping %d renames the measurement %s, which is configured by name: configure %s too", i, name, mm.Measurement)
+			}
+		}
+		for _, tag := range u.Tags {
+			if n, keep := mapKey(tag, mm.RenameTags, mm.DropTags); !keep || n != tag {
+				return errors.Errorf("measurement mapping %d renames or drops the tag %s", i, tag)
+			}
+		}
+		if !aggregated {
+			continue
+		}
+		for _, field := range u.Fields {
+			if n, keep := mapKey(field, mm.RenameFields, mm.DropFields); !keep || !contains(u.Fields, n) {
+				return errors.Errorf("measurement mapping %d renames or drops the field %s of the rollups", i, field)
+			}
+		}
+	}
+	return nil
+}
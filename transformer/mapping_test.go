@@ -0,0 +1,176 @@
+package transformer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func TestLoadMapping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"valid", `[{"event_type": "HttpStartStop", "measurement": "http"}, {"event_type": "LogMessage", "source_type": "RTR", "drop": true}]`, false},
+		{"unknown event type", `[{"event_type": "HttpRequest", "measurement": "http"}]`, true},
+		{"invalid JSON", `{"event_type": "HttpStartStop"}`, true},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "mapping.json")
+		if err := ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMapping(path); (err != nil) != test.wantErr {
+			t.Fatalf("TestLoadMapping %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestMapping(t *testing.T) {
+	mapping := Mapping{
+		{EventType: "HttpStartStop", Measurement: "http", RenameTags: map[string]string{"status_code": "status"}, DropTags: []string{"space_guid", "org_guid"}, RenameFields: map[string]string{"duration": "duration_s"}, DropFields: []string{"count"}},
+		{EventType: "LogMessage", SourceType: "RTR", Drop: true},
+		{EventType: "ContainerMetric", AddTags: map[string]string{"source": "cf"}, Fields: []string{"cpu", "memory"}, DropFields: []string{"memory"}},
+	}
+	tr := NewTransformer(ConfigTransformer{}, nil, nil, mapping, nil)
+
+	tests := []struct {
+		name            string
+		msg             string
+		wantMeasurement string
+		wantTags        []string
+		wantFields      []string
+		wantErr         error
+	}{
+		{"renamed", httpStartStop, "http", []string{"app", "app_guid", "space", "org", "instance", "method", "status", "peer_type"}, []string{"duration_s", "response_size"}, nil},
+		{"dropped", RTRLogMsg, "", nil, nil, ErrEventDiscarded},
+		{"selected fields", containerMetrics, "instance", []string{"app", "app_guid", "space", "space_guid", "org", "org_guid", "instance", "source"}, []string{"cpu"}, nil},
+		{"not mapped", LogMsg, "log", []string{"app", "app_guid", "space", "space_guid", "org", "org_guid", "instance", "type"}, []string{"count", "size"}, nil},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(test.msg), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := tr.ToPoint(&Envelope{Event: &e, Meta: appMeta})
+		if err != test.wantErr {
+			t.Fatalf("TestMapping %s: expected error %v got %v", test.name, test.wantErr, err)
+		}
+		if err != nil {
+			continue
+		}
+		if p.Name != test.wantMeasurement {
+			t.Fatalf("TestMapping %s: expected %v got %v", test.name, test.wantMeasurement, p.Name)
+		}
+
+		var tags, fields []string
+		for k := range p.Tags {
+			tags = append(tags, k)
+		}
+		pointFields := p.Fields
+		for k := range pointFields {
+			fields = append(fields, k)
+		}
+		if !sameKeys(tags, test.wantTags) || !sameKeys(fields, test.wantFields) {
+			t.Fatalf("TestMapping %s: expected tags %v fields %v got %v %v", test.name, test.wantTags, test.wantFields, tags, fields)
+		}
+	}
+}
+
+func sameKeys(got, want []string) bool {
+	set := func(keys []string) map[string]bool {
+		m := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			m[k] = true
+		}
+		return m
+	}
+	return reflect.DeepEqual(set(got), set(want))
+}
+
+func TestMappingEmptyPoint(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTransformer(ConfigTransformer{}, nil, nil, Mapping{{EventType: "LogMessage", Fields: []string{"bytes"}}}, nil)
+	if ps, err := tr.ToPoints(&Envelope{Event: &e, Meta: appMeta}); err != ErrEmptyPoint {
+		t.Fatalf("TestMappingEmptyPoint: expected ErrEmptyPoint, got %v, %v", ps, err)
+	}
+}
+
+func TestDefaultMappingFile(t *testing.T) {
+	mapping, err := LoadMapping(filepath.Join("..", "docs", "mapping.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTransformer(ConfigTransformer{}, nil, nil, mapping, nil)
+
+	for _, msg := range []string{LogMsg, AppLogMsg, RTRLogMsg, RTRAccessLogMsg, containerMetrics, httpStartStop, appValueMetric, valueMetric, counterEvent, errorEvent} {
+		envelope := func() *Envelope {
+			var e events.Envelope
+			if err := json.Unmarshal([]byte(msg), &e); err != nil {
+				t.Fatal(err)
+			}
+			env := &Envelope{Event: &e, Meta: appMeta}
+			if e.GetEventType() == events.Envelope_Error || msg == valueMetric || msg == counterEvent {
+				env.EnrichPlatform()
+			}
+			return env
+		}
+		want, wantErr := ToPoint(envelope())
+		got, err := tr.ToPoint(envelope())
+		if err != wantErr || !reflect.DeepEqual(got, want) {
+			t.Fatalf("TestDefaultMappingFile: expected %v, %v, got %v, %v", want, wantErr, got, err)
+		}
+	}
+}
+
+func TestMappingValidate(t *testing.T) {
+	usage := MappingUsage{
+		Measurements: []string{"http_request", "log"},
+		Aggregated:   []string{"instance"},
+		Fields:       []string{"cpu", "cpu_pct"},
+		Tags:         []string{PeerTypeTag},
+	}
+
+	tests := []struct {
+		name    string
+		mapping Mapping
+		wantErr bool
+	}{
+		{"default", nil, false},
+		{"renamed measurement", Mapping{{EventType: "HttpStartStop", Measurement: "http"}}, true},
+		{"renamed to a configured measurement", Mapping{{EventType: "LogMessage", SourceType: "RTR", Measurement: "log"}}, false},
+		{"renamed log measurement", Mapping{{EventType: "LogMessage", SourceType: "APP", Measurement: "app_log"}}, true},
+		{"renamed other measurement", Mapping{{EventType: "LogMessage", SourceType: "CELL", Measurement: "cell_event"}}, false},
+		{"dropped measurement", Mapping{{EventType: "HttpStartStop", Drop: true}}, false},
+		{"dropped tag", Mapping{{EventType: "HttpStartStop", DropTags: []string{PeerTypeTag}}}, true},
+		{"renamed tag", Mapping{{EventType: "HttpStartStop", RenameTags: map[string]string{PeerTypeTag: "peer"}}}, true},
+		{"dropped field", Mapping{{EventType: "ContainerMetric", DropFields: []string{"cpu"}}}, true},
+		{"renamed field", Mapping{{EventType: "ContainerMetric", RenameFields: map[string]string{"cpu": "cpu_percentage"}}}, true},
+		{"renamed to a rollup field", Mapping{{EventType: "ContainerMetric", RenameFields: map[string]string{"cpu": "cpu_pct"}}}, false},
+		{"field of another measurement", Mapping{{EventType: "HttpStartStop", DropFields: []string{"cpu"}}}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.mapping.Validate(usage); (err != nil) != test.wantErr {
+			t.Fatalf("TestMappingValidate %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package transformer
+
+import (
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pkg/errors"
+)
+
+// HTTPDedupe is how the HttpStartStop events emitted for the same request by
+// the gorouter (peer type Client) and by the app side (peer type Server) are
+// de-duplicated.
+type HTTPDedupe string
+
+const (
+	HTTPDedupeNone      HTTPDedupe = "none"      // keep both events, tagged by peer_type
+	HTTPDedupeRouter    HTTPDedupe = "router"    // keep only the events of the gorouter
+	HTTPDedupeCorrelate HTTPDedupe = "correlate" // merge the events of a request (see output.Correlator)
+)
+
+const (
+	// PeerTypeTag is the tag with the peer type (client or server) of the
+	// http_request points.
+	PeerTypeTag = "peer_type"
+
+	// RequestIDField is the field with the request ID added to the
+	// http_request points for the correlation. It is removed by the
+	// correlation and never written.
+	RequestIDField = "request_id"
+)
+
+// Decode implements envconfig.Decoder
+func (d *HTTPDedupe) Decode(value string) error {
+	switch v := HTTPDedupe(value); v {
+	case HTTPDedupeNone, HTTPDedupeRouter, HTTPDedupeCorrelate:
+		*d = v
+		return nil
+	}
+	return errors.Errorf("unknown HTTP de-duplication policy %q", value)
+}
+
+// keepHTTP returns false if the event is discarded by the de-duplication
+// policy.
+func (t *Transformer) keepHTTP(e *events.HttpStartStop) bool {
+	return t.cfg.HTTPDedupe != HTTPDedupeRouter || e.GetPeerType() == events.PeerType_Client
+}
+
+// withRequestID returns a function adding the request ID to the result of the
+// HttpStartStop converter, if the events are correlated.
+func (t *Transformer) withRequestID(e *events.HttpStartStop) func(*Point, error) (*Point, error) {
+	return func(p *Point, err error) (*Point, error) {
+		if err != nil || t.cfg.HTTPDedupe != HTTPDedupeCorrelate {
+			return p, err
+		}
+		if id := uuid2str(e.GetRequestId()); id != "" {
+			p.Fields[RequestIDField] = id
+		}
+		return p, nil
+	}
+}
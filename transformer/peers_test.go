@@ -0,0 +1,62 @@
+package transformer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func TestHTTPDedupe(t *testing.T) {
+	tests := []struct {
+		name          string
+		dedupe        HTTPDedupe
+		peer          events.PeerType
+		wantDiscarded bool
+		wantRequestID string
+	}{
+		{"none client", HTTPDedupeNone, events.PeerType_Client, false, ""},
+		{"none server", HTTPDedupeNone, events.PeerType_Server, false, ""},
+		{"router client", HTTPDedupeRouter, events.PeerType_Client, false, ""},
+		{"router server", HTTPDedupeRouter, events.PeerType_Server, true, ""},
+		{"correlate client", HTTPDedupeCorrelate, events.PeerType_Client, false, "b4f110c0-1948-47fa-6e96-7ca8e5ce0712"},
+		{"correlate server", HTTPDedupeCorrelate, events.PeerType_Server, false, "b4f110c0-1948-47fa-6e96-7ca8e5ce0712"},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(httpStartStop), &e); err != nil {
+			t.Fatal(err)
+		}
+		peer := test.peer
+		e.HttpStartStop.PeerType = &peer
+
+		tr := NewTransformer(ConfigTransformer{HTTPDedupe: test.dedupe}, nil, nil, nil, nil)
+		p, err := tr.ToPoint(&Envelope{Event: &e, Meta: appMeta2})
+		if test.wantDiscarded {
+			if err != ErrEventDiscarded {
+				t.Fatalf("TestHTTPDedupe %s: expected the event to be discarded, got %v, %v", test.name, p, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("TestHTTPDedupe %s: %v", test.name, err)
+		}
+		if want := map[events.PeerType]string{events.PeerType_Client: "client", events.PeerType_Server: "server"}[test.peer]; p.Tags[PeerTypeTag] != want {
+			t.Fatalf("TestHTTPDedupe %s: expected peer type %q, got %v", test.name, want, p.Tags)
+		}
+		if id, _ := p.Fields[RequestIDField].(string); id != test.wantRequestID {
+			t.Fatalf("TestHTTPDedupe %s: expected request ID %q, got %q", test.name, test.wantRequestID, id)
+		}
+	}
+}
+
+func TestHTTPDedupeDecode(t *testing.T) {
+	var d HTTPDedupe
+	if err := d.Decode("correlate"); err != nil || d != HTTPDedupeCorrelate {
+		t.Fatalf("TestHTTPDedupeDecode: got %q, %v", d, err)
+	}
+	if err := d.Decode("client"); err == nil {
+		t.Fatal("TestHTTPDedupeDecode: expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,24 @@
+package transformer
+
+// PlatformMetadata identifies the platform component (e.g. router, cell, UAA)
+// that emitted an envelope not related to any app.
+type PlatformMetadata struct {
+	Origin     string
+	Deployment string
+	Job        string
+	Index      string
+	IP         string
+}
+
+// EnrichPlatform adds the metadata of the platform component that emitted the
+// envelope. Unlike Enrich it does not query any Enricher, as all the metadata
+// is contained in the envelope itself.
+func (e *Envelope) EnrichPlatform() {
+	e.Platform = &PlatformMetadata{
+		Origin:     e.Event.GetOrigin(),
+		Deployment: e.Event.GetDeployment(),
+		Job:        e.Event.GetJob(),
+		Index:      e.Event.GetIndex(),
+		IP:         e.Event.GetIp(),
+	}
+}
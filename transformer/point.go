@@ -0,0 +1,127 @@
+package transformer
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kind describes what the value of a Point represents, so that outputs and
+// aggregations can handle it appropriately.
+type Kind int
+
+const (
+	KindEvent   Kind = iota // a single occurrence, e.g. an HTTP request or a log line
+	KindGauge               // the current value of a metric, e.g. the memory usage
+	KindCounter             // an increment of a counter
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindEvent:
+		return "event"
+	case KindGauge:
+		return "gauge"
+	case KindCounter:
+		return "counter"
+	}
+	return "unknown"
+}
+
+// Point is the output-agnostic representation of a data point produced from
+// an envelope. Outputs only have to serialize it.
+type Point struct {
+	Name   string
+	Tags   map[string]string
+	Fields map[string]interface{}
+	Time   time.Time
+	Kind   Kind
+}
+
+// NewPoint creates a Point. Tags with empty values are dropped, and the field
+// values are normalized to int64, float64, string or bool. It returns an
+// error if there are no fields or a field type is not supported. Values are
+// not validated (see Validator).
+func NewPoint(name string, tags map[string]string, fields map[string]interface{}, t time.Time, kind Kind) (*Point, error) {
+	if name == "" {
+		return nil, errors.New("point without name")
+	}
+	if len(fields) == 0 {
+		return nil, errors.Errorf("point %s without fields", name)
+	}
+
+	p := &Point{
+		Name:   name,
+		Tags:   make(map[string]string, len(tags)),
+		Fields: make(map[string]interface{}, len(fields)),
+		Time:   t,
+		Kind:   kind,
+	}
+	for k, v := range tags {
+		if v != "" {
+			p.Tags[k] = v
+		}
+	}
+	for k, v := range fields {
+		nv, err := normalizeField(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "point %s, field %s", name, k)
+		}
+		p.Fields[k] = nv
+	}
+	return p, nil
+}
+
+func normalizeField(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64, string, bool:
+		return v, nil
+	}
+	return nil, errors.Errorf("unsupported value %v (%T)", v, v)
+}
+
+// Series identifies the series of the point (name and tags).
+func (p *Point) Series() string {
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(p.Name)
+	for _, k := range keys {
+		b.WriteString("," + k + "=" + p.Tags[k])
+	}
+	return b.String()
+}
+
+// Points returns the points produced from the envelope by Transform.
+func (e *Envelope) Points() []*Point {
+	ps, _ := e.Output.([]*Point)
+	return ps
+}
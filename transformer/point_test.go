@@ -0,0 +1,91 @@
+package transformer
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+func TestNewPoint(t *testing.T) {
+	ts := time.Unix(0, 123456789012345678)
+	tests := []struct {
+		name       string
+		tags       map[string]string
+		fields     map[string]interface{}
+		wantTags   map[string]string
+		wantFields map[string]interface{}
+		wantErr    bool
+	}{
+		{
+			"normalized",
+			map[string]string{"app": "app", "level": ""},
+			map[string]interface{}{"count": 1, "size": uint64(12), "cpu": float32(0.5), "type": "OUT", "ok": true},
+			map[string]string{"app": "app"},
+			map[string]interface{}{"count": int64(1), "size": int64(12), "cpu": 0.5, "type": "OUT", "ok": true},
+			false,
+		},
+		{"no fields", nil, map[string]interface{}{}, nil, nil, true},
+		{
+			"not validated",
+			map[string]string{"app": "app"},
+			map[string]interface{}{"memory_pct": math.Inf(1)},
+			map[string]string{"app": "app"},
+			map[string]interface{}{"memory_pct": math.Inf(1)},
+			false,
+		},
+		{"unsupported", nil, map[string]interface{}{"tags": []string{"a"}}, nil, nil, true},
+	}
+
+	for _, test := range tests {
+		p, err := NewPoint("test", test.tags, test.fields, ts, KindGauge)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("TestNewPoint %s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		want := &Point{Name: "test", Tags: test.wantTags, Fields: test.wantFields, Time: ts, Kind: KindGauge}
+		if !reflect.DeepEqual(p, want) {
+			t.Fatalf("TestNewPoint %s: expected %+v got %+v", test.name, want, p)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		wantKind Kind
+		wantErr  error
+	}{
+		{"log message", LogMsg, KindEvent, nil},
+		{"container metrics", containerMetrics, KindGauge, nil},
+		{"unknown log message", UnknownLogMsg, 0, ErrEventDiscarded},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(test.msg), &e); err != nil {
+			t.Fatal(err)
+		}
+		env := &Envelope{Event: &e, Meta: appMeta}
+
+		if err := Transform(env); err != test.wantErr {
+			t.Fatalf("TestTransform %s: expected error %v got %v", test.name, test.wantErr, err)
+		}
+		ps := env.Points()
+		if test.wantErr != nil {
+			if ps != nil {
+				t.Fatalf("TestTransform %s: expected no points got %v", test.name, ps)
+			}
+			continue
+		}
+		if len(ps) != 1 || ps[0].Kind != test.wantKind {
+			t.Fatalf("TestTransform %s: expected 1 %v point got %v", test.name, test.wantKind, ps)
+		}
+	}
+}
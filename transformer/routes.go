@@ -0,0 +1,347 @@
+package transformer
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/pkg/errors"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+// RouteTag is the tag with the normalized path of the http_request points.
+const RouteTag = "route"
+
+// AllApps is the key of the route templates applied to all apps.
+const AllApps = "*"
+
+// maxRouteSettings is the maximum number of distinct "routes" settings whose
+// parsed templates are cached.
+const maxRouteSettings = 1000
+
+// routesPruneSteps is how many times per window the routes of an app over
+// the limit can be expired, instead of at every new route.
+const routesPruneSteps = 60
+
+// routeTemplate matches the paths of a route, e.g. /users/:id/orders/*:
+// segments starting with ":" (or enclosed in braces) match any segment, and
+// a final "*" matches any number of segments.
+type routeTemplate struct {
+	template string
+	segments []string
+	rest     bool
+}
+
+func parseRouteTemplate(template string) (routeTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return routeTemplate{}, errors.Errorf("route template %q does not start with /", template)
+	}
+	t := routeTemplate{template: template, segments: pathSegments(template)}
+	for i, s := range t.segments {
+		if s != "*" {
+			continue
+		}
+		if i != len(t.segments)-1 {
+			return routeTemplate{}, errors.Errorf("route template %q has * before the last segment", template)
+		}
+		t.segments, t.rest = t.segments[:i], true
+	}
+	return t, nil
+}
+
+func parseRouteTemplates(templates []string) ([]routeTemplate, error) {
+	ts := make([]routeTemplate, 0, len(templates))
+	for _, template := range templates {
+		t, err := parseRouteTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+func (t routeTemplate) match(segments []string) bool {
+	if len(segments) < len(t.segments) || (!t.rest && len(segments) > len(t.segments)) {
+		return false
+	}
+	for i, s := range t.segments {
+		if !strings.HasPrefix(s, ":") && !(strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}")) && s != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pathSegments returns the non-empty segments of a path.
+func pathSegments(path string) []string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+var (
+	numericSegmentRe = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegmentRe    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegmentRe     = regexp.MustCompile(`^[0-9a-fA-F]+(-[0-9a-fA-F]+)*$`)
+)
+
+// normalizeSegment replaces the IDs in a path segment with placeholders: hex
+// IDs are at least 8 characters long, contain a digit and can be split by
+// hyphens (e.g. a truncated UUID).
+func normalizeSegment(s string) string {
+	switch {
+	case numericSegmentRe.MatchString(s):
+		return ":id"
+	case uuidSegmentRe.MatchString(s):
+		return ":uuid"
+	case len(s) >= 8 && hexSegmentRe.MatchString(s) && strings.ContainsAny(s, "0123456789"):
+		return ":hex"
+	}
+	return s
+}
+
+// uriPath returns the path of the URI of an HttpStartStop event (a full URL
+// or a path), without the query string and fragment.
+func uriPath(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+		if i := strings.Index(uri, "/"); i >= 0 {
+			return uri[i:]
+		}
+		return "/"
+	}
+	return uri
+}
+
+// RouteCallback is called for every route tag; capped is true if the route
+// was replaced by OtherTagValue because the app has too many routes.
+type RouteCallback func(capped bool)
+
+// Routes normalizes the URIs of the HttpStartStop events into the route tag:
+// the path is matched against the route templates of the app (from its
+// "routes" setting, then from the routes file) and, if none matches, the
+// numeric, UUID and hex segments are replaced by placeholders. The number of
+// distinct routes of each app in a sliding window is limited: further routes
+// are replaced by OtherTagValue.
+type Routes struct {
+	templates map[string][]routeTemplate // app GUID or AllApps -> templates
+	maxRoutes int
+	window    time.Duration
+	cb        RouteCallback
+	now       func() time.Time
+
+	l         sync.Mutex
+	settings  map[string]*routeSetting // "routes" setting -> templates
+	routes    map[string]*appRoutes    // app GUID -> routes
+	lastPrune time.Time
+}
+
+type routeSetting struct {
+	templates []routeTemplate
+	lastUsed  time.Time
+}
+
+type appRoutes struct {
+	routes    map[string]time.Time // route -> last seen
+	lastPrune time.Time
+}
+
+// NewRoutes creates a Routes using the specified templates of each app GUID
+// (or of all apps, with the AllApps key) and accepting up to maxRoutes
+// routes per app seen in the window. The callback, if not nil, is called for
+// every route.
+func NewRoutes(templates map[string][]string, maxRoutes int, window time.Duration, cb RouteCallback) (*Routes, error) {
+	if maxRoutes <= 0 {
+		return nil, errors.New("the maximum number of routes per app must be positive")
+	}
+	if window <= 0 {
+		return nil, errors.New("the routes window must be positive")
+	}
+	r := &Routes{
+		templates: make(map[string][]routeTemplate, len(templates)),
+		maxRoutes: maxRoutes,
+		window:    window,
+		cb:        cb,
+		now:       time.Now,
+		settings:  make(map[string]*routeSetting),
+		routes:    make(map[string]*appRoutes),
+	}
+	for app, ts := range templates {
+		var err error
+		if r.templates[app], err = parseRouteTemplates(ts); err != nil {
+			return nil, errors.Wrapf(err, "parsing the route templates of %s", app)
+		}
+	}
+	return r, nil
+}
+
+// LoadRoutes loads the route templates from a JSON file containing an object
+// mapping app GUIDs (or AllApps) to lists of templates. If path is empty,
+// only the templates of the app settings are used.
+func LoadRoutes(path string, maxRoutes int, window time.Duration, cb RouteCallback) (*Routes, error) {
+	var templates map[string][]string
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening routes file")
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&templates); err != nil {
+			return nil, errors.Wrap(err, "parsing routes file")
+		}
+	}
+	return NewRoutes(templates, maxRoutes, window, cb)
+}
+
+// route returns the route tag of the URI of an HttpStartStop event of the
+// app.
+func (r *Routes) route(uri string, meta enricher.AppMetadata) string {
+	segments := pathSegments(uriPath(uri))
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	now := r.now()
+	if now.Sub(r.lastPrune) >= r.window {
+		r.pruneWithLock(now)
+	}
+
+	route := ""
+	for _, ts := range [][]routeTemplate{r.settingTemplatesWithLock(meta, now), r.templates[meta.AppGUID], r.templates[AllApps]} {
+		for _, t := range ts {
+			if t.match(segments) {
+				route = t.template
+				break
+			}
+		}
+		if route != "" {
+			break
+		}
+	}
+	if route == "" {
+		normalized := make([]string, len(segments))
+		for i, s := range segments {
+			normalized[i] = normalizeSegment(s)
+		}
+		route = "/" + strings.Join(normalized, "/")
+	}
+
+	accepted := r.acceptWithLock(meta.AppGUID, route, now)
+	if r.cb != nil {
+		r.cb(!accepted)
+	}
+	if !accepted {
+		return OtherTagValue
+	}
+	return route
+}
+
+// settingTemplatesWithLock returns the templates of the "routes" setting of
+// the app; invalid settings are ignored. The parsed settings are cached: the
+// least recently used one is forgotten when the cache is full.
+func (r *Routes) settingTemplatesWithLock(meta enricher.AppMetadata, now time.Time) []routeTemplate {
+	setting := meta.Settings[enricher.SettingRoutes]
+	if setting == "" {
+		return nil
+	}
+	rs, found := r.settings[setting]
+	if !found {
+		rs = &routeSetting{}
+		for _, template := range strings.Split(setting, ",") {
+			if t, err := parseRouteTemplate(strings.TrimSpace(template)); err == nil {
+				rs.templates = append(rs.templates, t)
+			}
+		}
+		if len(r.settings) >= maxRouteSettings {
+			r.evictSettingWithLock()
+		}
+		r.settings[setting] = rs
+	}
+	rs.lastUsed = now
+	return rs.templates
+}
+
+// evictSettingWithLock forgets the least recently used setting.
+func (r *Routes) evictSettingWithLock() {
+	var oldest string
+	var oldestUsed time.Time
+	for s, rs := range r.settings {
+		if oldest == "" || rs.lastUsed.Before(oldestUsed) {
+			oldest, oldestUsed = s, rs.lastUsed
+		}
+	}
+	delete(r.settings, oldest)
+}
+
+// acceptWithLock returns true if the route of the app is known or there is
+// room for it. The routes not seen in the window are forgotten.
+func (r *Routes) acceptWithLock(appGUID, route string, now time.Time) bool {
+	ar, found := r.routes[appGUID]
+	if !found {
+		ar = &appRoutes{routes: make(map[string]time.Time)}
+		r.routes[appGUID] = ar
+	}
+	if _, found := ar.routes[route]; found || len(ar.routes) < r.maxRoutes {
+		ar.routes[route] = now
+		return true
+	}
+	if now.Sub(ar.lastPrune) >= r.window/routesPruneSteps {
+		ar.lastPrune = now
+		ar.prune(now.Add(-r.window))
+	}
+	if len(ar.routes) < r.maxRoutes {
+		ar.routes[route] = now
+		return true
+	}
+	return false
+}
+
+// pruneWithLock forgets the routes and settings not seen in the window, and
+// the apps without routes.
+func (r *Routes) pruneWithLock(now time.Time) {
+	r.lastPrune = now
+	expire := now.Add(-r.window)
+	for s, rs := range r.settings {
+		if rs.lastUsed.Before(expire) {
+			delete(r.settings, s)
+		}
+	}
+	for app, ar := range r.routes {
+		ar.prune(expire)
+		if len(ar.routes) == 0 {
+			delete(r.routes, app)
+		}
+	}
+}
+
+func (ar *appRoutes) prune(expire time.Time) {
+	for route, t := range ar.routes {
+		if t.Before(expire) {
+			delete(ar.routes, route)
+		}
+	}
+}
+
+// withRoute returns a function adding the route tag to the result of the
+// HttpStartStop converter, if enabled.
+func (t *Transformer) withRoute(e *events.HttpStartStop, meta enricher.AppMetadata) func(*Point, error) (*Point, error) {
+	return func(p *Point, err error) (*Point, error) {
+		if err != nil || t.routes == nil {
+			return p, err
+		}
+		p.Tags[RouteTag] = t.routes.route(e.GetUri(), meta)
+		return p, nil
+	}
+}
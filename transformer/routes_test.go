@@ -0,0 +1,220 @@
+package transformer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/rakutentech/cf-metrics-refinery/enricher"
+)
+
+func TestRoutes(t *testing.T) {
+	r, err := NewRoutes(map[string][]string{
+		"app-guid": {"/users/:id/orders/{order}", "/static/*"},
+		AllApps:    {"/health"},
+	}, 100, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := enricher.AppMetadata{AppGUID: "app-guid"}
+	annotated := enricher.AppMetadata{AppGUID: "app-guid", Settings: map[string]string{"routes": "/users/:name, invalid, /v2/*"}}
+
+	tests := []struct {
+		name string
+		uri  string
+		meta enricher.AppMetadata
+		want string
+	}{
+		{"root", "http://app.example.com", meta, "/"},
+		{"query string", "http://app.example.com/search?q=1#top", meta, "/search"},
+		{"path only", "/search/", meta, "/search"},
+		{"numeric", "http://app.example.com/items/42/details", meta, "/items/:id/details"},
+		{"uuid", "http://app.example.com/apps/6a243643-3d2d-4f44-5933-fa6f0a1b2c3d", meta, "/apps/:uuid"},
+		{"hex", "http://app.example.com/commits/0a1b2c3d4e5f", meta, "/commits/:hex"},
+		{"short hex", "http://app.example.com/commits/0a1b", meta, "/commits/0a1b"},
+		{"words", "http://app.example.com/facade/deadbeef", meta, "/facade/deadbeef"},
+		{"app template", "http://app.example.com/users/john/orders/7", meta, "/users/:id/orders/{order}"},
+		{"rest template", "http://app.example.com/static/css/main.css", meta, "/static/*"},
+		{"rest template without rest", "http://app.example.com/static", meta, "/static/*"},
+		{"all apps template", "http://app.example.com/health", enricher.AppMetadata{AppGUID: "other"}, "/health"},
+		{"other app", "http://app.example.com/users/john/orders/7", enricher.AppMetadata{AppGUID: "other"}, "/users/john/orders/:id"},
+		{"setting first", "http://app.example.com/users/john", annotated, "/users/:name"},
+		{"setting rest", "http://app.example.com/v2/apps/42", annotated, "/v2/*"},
+		{"setting falls back", "http://app.example.com/static/app.js", annotated, "/static/*"},
+	}
+
+	for _, test := range tests {
+		if got := r.route(test.uri, test.meta); got != test.want {
+			t.Fatalf("TestRoutes %s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRoutesMaxValues(t *testing.T) {
+	var capped int
+	r, err := NewRoutes(nil, 2, time.Hour, func(c bool) {
+		if c {
+			capped++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app1 := enricher.AppMetadata{AppGUID: "app1"}
+	app2 := enricher.AppMetadata{AppGUID: "app2"}
+
+	for _, test := range []struct {
+		uri  string
+		meta enricher.AppMetadata
+		want string
+	}{
+		{"/a", app1, "/a"},
+		{"/b", app1, "/b"},
+		{"/c", app1, OtherTagValue},
+		{"/a", app1, "/a"},
+		{"/c", app2, "/c"},
+	} {
+		if got := r.route(test.uri, test.meta); got != test.want {
+			t.Fatalf("TestRoutesMaxValues %s of %s: expected %q, got %q", test.uri, test.meta.AppGUID, test.want, got)
+		}
+	}
+	if capped != 1 {
+		t.Fatalf("TestRoutesMaxValues: expected 1 capped route, got %d", capped)
+	}
+}
+
+func TestRoutesWindow(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	r, err := NewRoutes(nil, 1, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	app := enricher.AppMetadata{AppGUID: "app"}
+
+	for _, test := range []struct {
+		after time.Duration
+		uri   string
+		want  string
+	}{
+		{0, "/a", "/a"},
+		{30 * time.Minute, "/b", OtherTagValue},
+		{30 * time.Minute, "/a", "/a"},
+		// /a was last seen an hour ago: /b takes its place
+		{time.Hour + time.Second, "/b", "/b"},
+		{time.Minute, "/a", OtherTagValue},
+	} {
+		now = now.Add(test.after)
+		if got := r.route(test.uri, app); got != test.want {
+			t.Fatalf("TestRoutesWindow %s after %v: expected %q, got %q", test.uri, test.after, test.want, got)
+		}
+	}
+}
+
+func TestRoutesSettingsCache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	r, err := NewRoutes(nil, maxRouteSettings+1, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return now }
+	use := func(i int) {
+		now = now.Add(time.Millisecond)
+		meta := enricher.AppMetadata{AppGUID: "app", Settings: map[string]string{enricher.SettingRoutes: "/v" + strconv.Itoa(i) + "/*"}}
+		if got, want := r.route("/v"+strconv.Itoa(i)+"/users", meta), "/v"+strconv.Itoa(i)+"/*"; got != want {
+			t.Fatalf("TestRoutesSettingsCache: expected %q, got %q", want, got)
+		}
+	}
+
+	for i := 0; i < maxRouteSettings; i++ {
+		use(i)
+	}
+	// the setting 0 is in use: the least recently used one is forgotten
+	use(0)
+	use(maxRouteSettings)
+	if len(r.settings) != maxRouteSettings {
+		t.Fatalf("TestRoutesSettingsCache: expected %d cached settings, got %d", maxRouteSettings, len(r.settings))
+	}
+	if _, found := r.settings["/v0/*"]; !found {
+		t.Fatal("TestRoutesSettingsCache: expected the setting in use to be kept")
+	}
+	if _, found := r.settings["/v1/*"]; found {
+		t.Fatal("TestRoutesSettingsCache: expected the least recently used setting to be forgotten")
+	}
+
+	// the settings not used in the window expire
+	now = now.Add(2 * time.Hour)
+	use(0)
+	if len(r.settings) != 1 {
+		t.Fatalf("TestRoutesSettingsCache: expected 1 cached setting after the window, got %d", len(r.settings))
+	}
+}
+
+func TestNewRoutesErrors(t *testing.T) {
+	for _, templates := range []map[string][]string{
+		{AllApps: {"users/:id"}},
+		{AllApps: {"/static/*/css"}},
+	} {
+		if _, err := NewRoutes(templates, 100, time.Hour, nil); err == nil {
+			t.Fatalf("TestNewRoutesErrors: expected an error for %v", templates)
+		}
+	}
+	if _, err := NewRoutes(nil, 0, time.Hour, nil); err == nil {
+		t.Fatal("TestNewRoutesErrors: expected an error for a zero maximum")
+	}
+	if _, err := NewRoutes(nil, 100, 0, nil); err == nil {
+		t.Fatal("TestNewRoutesErrors: expected an error for a zero window")
+	}
+}
+
+func TestLoadRoutes(t *testing.T) {
+	f, err := ioutil.TempFile("", "routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := json.NewEncoder(f).Encode(map[string][]string{AllApps: {"/users/:id"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err := LoadRoutes(f.Name(), 100, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.route("/users/john", enricher.AppMetadata{}); got != "/users/:id" {
+		t.Fatalf("TestLoadRoutes: expected /users/:id, got %q", got)
+	}
+
+	if _, err := LoadRoutes(f.Name()+".missing", 100, time.Hour, nil); err == nil {
+		t.Fatal("TestLoadRoutes: expected an error for a missing file")
+	}
+}
+
+func TestRouteTag(t *testing.T) {
+	r, err := NewRoutes(nil, 100, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, routes := range []*Routes{nil, r} {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(httpStartStop), &e); err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewTransformer(ConfigTransformer{}, nil, nil, nil, routes).ToPoint(&Envelope{Event: &e, Meta: appMeta2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if routes != nil {
+			want = "/eureka/apps/BACKEND/:hex"
+		}
+		if got := p.Tags[RouteTag]; got != want {
+			t.Fatalf("TestRouteTag: expected %q, got %q", want, got)
+		}
+	}
+}
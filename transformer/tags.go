@@ -0,0 +1,91 @@
+package transformer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Regexp is a regular expression that can be decoded by envconfig.
+type Regexp struct {
+	*regexp.Regexp
+}
+
+// Decode implements envconfig.Decoder
+func (r *Regexp) Decode(value string) error {
+	if value == "" {
+		r.Regexp = nil
+		return nil
+	}
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return errors.Wrapf(err, "compiling %q", value)
+	}
+	r.Regexp = re
+	return nil
+}
+
+// TagPolicy adapts the tags of the points to the conventions of an output.
+// It is applied in order: Drop, Rename, then Replace, Lowercase and
+// MaxLength to the values of all tags. Tags left with an empty value are
+// dropped.
+type TagPolicy struct {
+	Drop        []string          `desc:"Tags not written (e.g. app_guid,space_guid,org_guid)"`                            // CFMR_<OUTPUT>_TAGS_DROP
+	Rename      map[string]string `desc:"Tags renamed, as old:new pairs (e.g. org:organization)"`                          // CFMR_<OUTPUT>_TAGS_RENAME
+	Replace     Regexp            `desc:"Regular expression matching the parts of the tag values to replace"`              // CFMR_<OUTPUT>_TAGS_REPLACE
+	ReplaceWith string            `default:"_" desc:"Replacement of the parts of the tag values matching Replace"`         // CFMR_<OUTPUT>_TAGS_REPLACEWITH
+	Lowercase   bool              `default:"false" desc:"Lowercase the tag values"`                                        // CFMR_<OUTPUT>_TAGS_LOWERCASE
+	MaxLength   int               `default:"0" desc:"Maximum length in bytes of the tag values (if 0, there is no limit)"` // CFMR_<OUTPUT>_TAGS_MAXLENGTH
+}
+
+func (t *TagPolicy) empty() bool {
+	return len(t.Drop) == 0 && len(t.Rename) == 0 && t.Replace.Regexp == nil && !t.Lowercase && t.MaxLength <= 0
+}
+
+// Apply returns the point with the policy applied to its tags. The point is
+// not modified, as it can be written to other outputs or retried.
+func (t *TagPolicy) Apply(p *Point) *Point {
+	if t.empty() {
+		return p
+	}
+
+	tags := make(map[string]string, len(p.Tags))
+	for k, v := range p.Tags {
+		tags[k] = v
+	}
+	for _, k := range t.Drop {
+		delete(tags, k)
+	}
+	renamed := make(map[string]string, len(t.Rename))
+	for from, to := range t.Rename {
+		if v, ok := tags[from]; ok {
+			delete(tags, from)
+			renamed[to] = v
+		}
+	}
+	for k, v := range renamed {
+		tags[k] = v
+	}
+
+	for k, v := range tags {
+		if t.Replace.Regexp != nil {
+			v = t.Replace.ReplaceAllLiteralString(v, t.ReplaceWith)
+		}
+		if t.Lowercase {
+			v = strings.ToLower(v)
+		}
+		if t.MaxLength > 0 {
+			v = truncate(v, t.MaxLength)
+		}
+		if v == "" {
+			delete(tags, k)
+		} else {
+			tags[k] = v
+		}
+	}
+
+	c := *p
+	c.Tags = tags
+	return &c
+}
@@ -0,0 +1,63 @@
+package transformer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagPolicy(t *testing.T) {
+	tags := map[string]string{
+		"app":      "My App (v2)",
+		"app_guid": "00000000-0000-0000-0000-000000000000",
+		"org":      "Org",
+		"space":    "()",
+	}
+	var replace Regexp
+	if err := replace.Decode(`[^A-Za-z0-9_.-]+`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		policy TagPolicy
+		want   map[string]string
+	}{
+		{"empty", TagPolicy{}, tags},
+		{
+			"drop and rename",
+			TagPolicy{Drop: []string{"app_guid"}, Rename: map[string]string{"org": "organization", "app": "application"}},
+			map[string]string{"application": "My App (v2)", "organization": "Org", "space": "()"},
+		},
+		{
+			"normalize values",
+			TagPolicy{Replace: replace, ReplaceWith: "", Lowercase: true, MaxLength: 8},
+			map[string]string{"app": "myappv2", "app_guid": "00000000", "org": "org"},
+		},
+		{
+			"replace",
+			TagPolicy{Replace: replace, ReplaceWith: "_"},
+			map[string]string{"app": "My_App_v2_", "app_guid": "00000000-0000-0000-0000-000000000000", "org": "Org", "space": "_"},
+		},
+	}
+
+	for _, test := range tests {
+		p := &Point{Name: "test", Tags: tags, Fields: map[string]interface{}{"count": int64(1)}}
+		got := test.policy.Apply(p)
+		if !reflect.DeepEqual(got.Tags, test.want) {
+			t.Fatalf("TestTagPolicy %s: expected %v got %v", test.name, test.want, got.Tags)
+		}
+		if p.Tags["app"] != "My App (v2)" || len(p.Tags) != 4 {
+			t.Fatalf("TestTagPolicy %s: the point was modified: %v", test.name, p.Tags)
+		}
+	}
+}
+
+func TestRegexpDecode(t *testing.T) {
+	var r Regexp
+	if err := r.Decode("["); err == nil {
+		t.Fatal("TestRegexpDecode: expected an error for an invalid regular expression")
+	}
+	if err := r.Decode(""); err != nil || r.Regexp != nil {
+		t.Fatalf("TestRegexpDecode: expected no regular expression, got %v (%v)", r.Regexp, err)
+	}
+}
@@ -197,6 +197,8 @@ var appMeta2 = enricher.AppMetadata{
 	SpaceGUID: "10000000-0000-0000-0000-000000000002",
 	Org:       "org2",
 	OrgGUID:   "20000000-0000-0000-0000-000000000002",
+
+	Foundation: "foundation2",
 }
 
 var httpStartStopPointTags = map[string]string{
@@ -209,6 +211,8 @@ var httpStartStopPointTags = map[string]string{
 	"space_guid":  "10000000-0000-0000-0000-000000000002",
 	"method":      "PUT",
 	"status_code": "200",
+	"foundation":  "foundation2",
+	"peer_type":   "client",
 }
 
 var httpStartStopPointFields = map[string]interface{}{
@@ -216,3 +220,88 @@ var httpStartStopPointFields = map[string]interface{}{
 	"duration":      0,
 	"response_size": 0,
 }
+
+const valueMetric = `{
+	"origin": "gorouter",
+	"eventType": 6,
+	"timestamp": 123456789012345678,
+	"deployment": "cf",
+	"job": "router",
+	"index": "c9ba631e-cbb7-42c7-b4d4-74adaf140685",
+	"ip": "192.168.0.51",
+	"valueMetric": {
+		"name": "latency",
+		"value": 12.5,
+		"unit": "ms"
+	}
+}`
+
+const counterEvent = `{
+	"origin": "gorouter",
+	"eventType": 7,
+	"timestamp": 123456789012345678,
+	"deployment": "cf",
+	"job": "router",
+	"index": "c9ba631e-cbb7-42c7-b4d4-74adaf140685",
+	"ip": "192.168.0.51",
+	"counterEvent": {
+		"name": "total_requests",
+		"delta": 5,
+		"total": 1024
+	}
+}`
+
+var platformPointTags = map[string]string{
+	"origin":     "gorouter",
+	"deployment": "cf",
+	"job":        "router",
+	"index":      "c9ba631e-cbb7-42c7-b4d4-74adaf140685",
+	"ip":         "192.168.0.51",
+}
+
+const appValueMetric = `{
+	"origin": "metric_registrar",
+	"eventType": 6,
+	"timestamp": 123456789012345678,
+	"job": "cell",
+	"index": "0",
+	"tags": {"app_id": "00000000-0000-0000-0000-000000000000"},
+	"valueMetric": {
+		"name": "queue_length",
+		"value": 7,
+		"unit": "jobs"
+	}
+}`
+
+const errorEvent = `{
+	"origin": "loggregator",
+	"eventType": 8,
+	"timestamp": 123456789012345678,
+	"deployment": "cf",
+	"job": "doppler",
+	"index": "0",
+	"ip": "192.168.0.52",
+	"error": {
+		"source": "doppler",
+		"code": 3,
+		"message": "dropped messages"
+	}
+}`
+
+// RTRAccessLogMsg contains accessLogLine
+const RTRAccessLogMsg = `{
+	"origin": "gorouter",
+	"eventType": 5,
+	"timestamp": 123456789012345678,
+	"job": "router",
+	"index": "c9ba631e-cbb7-42c7-b4d4-74adaf140685",
+	"ip": "192.168.0.50",
+	"logMessage": {
+		"message": "YXBwLmV4YW1wbGUuY29tIC0gWzIwMTktMDEtMDJUMDM6MDQ6MDUuNjc4KzAwMDBdICJHRVQgL3YyL2luZm8/eD0xIEhUVFAvMS4xIiAyMDAgMTIgMTIzNCAiLSIgImN1cmwvNy41OC4wIiAiMTAuMC4wLjE6NTQzMjEiICIxMC4wLjEuNTo2MTAwMSIgeF9mb3J3YXJkZWRfZm9yOiIxLjIuMy40LCAxMC4wLjAuMSIgeF9mb3J3YXJkZWRfcHJvdG86Imh0dHBzIiB2Y2FwX3JlcXVlc3RfaWQ6ImI0ZDFmMmE4LTFjMmQtNGU1Zi04YTliLTBjMWQyZTNmNGE1YiIgcmVzcG9uc2VfdGltZTowLjAxMjM0NSBnb3JvdXRlcl90aW1lOjAuMDAwMTIzIGFwcF9pZDoiMDAwMDAwMDAtMDAwMC0wMDAwLTAwMDAtMDAwMDAwMDAwMDAzIiBhcHBfaW5kZXg6IjEi",
+		"message_type": 1,
+		"timestamp": 123456789012345000,
+		"app_id": "00000000-0000-0000-0000-000000000003",
+		"source_type": "RTR",
+		"source_instance": "1"
+	}
+}`
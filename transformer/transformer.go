@@ -12,8 +12,17 @@ import (
 type Envelope struct {
 	Event  *events.Envelope
 	Meta   enricher.AppMetadata
+	Source string // where the event was read from (e.g. the Kafka topic)
 	Input  interface{}
 	Output interface{}
+
+	// Platform is set, instead of Meta, for envelopes emitted by platform
+	// components (see EnrichPlatform).
+	Platform *PlatformMetadata
+
+	// SampleRate is the fraction of similar events that were kept when
+	// sampling; it is 0 if the event was not sampled.
+	SampleRate float64
 }
 
 var ErrEventDiscarded = errors.New("event discarded")
@@ -28,6 +37,10 @@ func (e *Envelope) AppGuid() string {
 
 	case events.Envelope_ContainerMetric:
 		return e.Event.GetContainerMetric().GetApplicationId()
+
+	case events.Envelope_ValueMetric, events.Envelope_CounterEvent:
+		// only the metrics emitted by apps carry their GUID
+		return e.Event.GetTags()[enricher.TagAppGUID]
 	}
 
 	return ""
@@ -39,7 +52,17 @@ func (e *Envelope) Enrich(E enricher.Enricher) error {
 		return errors.New("envelope does not contain an app GUID")
 	}
 
-	md, err := E.GetAppMetadata(appGUID)
+	if r, ok := E.(enricher.Router); ok {
+		E = r.Route(e.Source, e.Event.GetDeployment())
+	}
+
+	var md enricher.AppMetadata
+	var err error
+	if te, ok := E.(enricher.TagsEnricher); ok {
+		md, err = te.GetAppMetadataWithTags(appGUID, e.Event.GetTags())
+	} else {
+		md, err = E.GetAppMetadata(appGUID)
+	}
 	if err != nil {
 		return errors.Wrap(err, "getting app metadata for envelope")
 	}
@@ -48,6 +48,8 @@ func TestAppGuid(t *testing.T) {
 		{"RTR log message", RTRLogMsg, "00000000-0000-0000-0000-000000000003"},
 		{"container metrics", containerMetrics, "00000000-0000-0000-0000-000000000001"},
 		{"httpStartStop", httpStartStop, "be268fe2-00cc-41c6-8b7f-0fdb65e25060"},
+		{"app value metric", appValueMetric, "00000000-0000-0000-0000-000000000000"},
+		{"platform value metric", valueMetric, ""},
 	}
 
 	for _, test := range tests {
@@ -126,3 +128,61 @@ func TestEnrich(t *testing.T) {
 	}
 
 }
+
+func TestEnrichRouted(t *testing.T) {
+	def := mockEnricher()
+	east := mockEnricher("00000000-0000-0000-0000-000000000000")
+	router := enricher.NewFoundationRouter(def)
+	if err := router.Add(east, []string{"east-topic"}, []string{"cf-east"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		source     string
+		deployment string
+		wantErr    bool
+	}{
+		{"routed by source", "east-topic", "", false},
+		{"routed by deployment", "other-topic", "cf-east", false},
+		{"default", "other-topic", "cf-west", true},
+	}
+
+	for _, test := range tests {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(LogMsg), &e); err != nil {
+			t.Fatal(err)
+		}
+		e.Deployment = &test.deployment
+
+		envelope := &Envelope{Event: &e, Source: test.source}
+		err := envelope.Enrich(router)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("%s: Transformer.Enrich() error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestEnrichWithTags(t *testing.T) {
+	var e events.Envelope
+	if err := json.Unmarshal([]byte(RTRLogMsg), &e); err != nil {
+		t.Fatal(err)
+	}
+	e.Tags = map[string]string{
+		enricher.TagAppName:   "tagapp",
+		enricher.TagAppGUID:   "00000000-0000-0000-0000-000000000003",
+		enricher.TagSpaceName: "tagspace",
+		enricher.TagSpaceGUID: "10000000-0000-0000-0000-000000000003",
+		enricher.TagOrgName:   "tagorg",
+		enricher.TagOrgGUID:   "20000000-0000-0000-0000-000000000003",
+	}
+
+	// the parent enricher does not know the app, so metadata must come from the tags
+	envelope := &Envelope{Event: &e}
+	if err := envelope.Enrich(enricher.NewEnvelopeTags(mockEnricher(), "foundation", false)); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Meta.App != "tagapp" || envelope.Meta.Org != "tagorg" || envelope.Meta.Foundation != "foundation" {
+		t.Fatalf("Transformer.Enrich() unexpected metadata %+v", envelope.Meta)
+	}
+}
@@ -0,0 +1,201 @@
+package transformer
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ValidationAction is what the Validator does with an invalid value.
+type ValidationAction string
+
+const (
+	ActionFix        ValidationAction = "fix"        // fix the value (see ConfigValidator)
+	ActionDropField  ValidationAction = "drop_field" // drop the tag or field
+	ActionDropPoint  ValidationAction = "drop_point" // drop the whole point
+	ActionQuarantine ValidationAction = "quarantine" // drop the whole point and keep it for inspection
+)
+
+// Decode implements envconfig.Decoder
+func (a *ValidationAction) Decode(value string) error {
+	switch v := ValidationAction(value); v {
+	case ActionFix, ActionDropField, ActionDropPoint, ActionQuarantine:
+		*a = v
+		return nil
+	}
+	return errors.Errorf("unknown validation action %q", value)
+}
+
+// Problems found by the Validator, used as reasons in the counts.
+const (
+	ReasonNonFinite        = "non_finite"
+	ReasonEmptyTag         = "empty_tag"
+	ReasonOversizeString   = "oversize_string"
+	ReasonInvalidTimestamp = "invalid_timestamp"
+	ReasonNoFields         = "no_fields"
+)
+
+type ConfigValidator struct {
+	NonFinite        ValidationAction `default:"drop_field" desc:"Action for NaN and infinite fields (fix replaces them with 0 or the max float)"` // CFMR_VALIDATOR_NONFINITE
+	EmptyTag         ValidationAction `default:"fix" desc:"Action for tags with empty key or blank value (fix drops the tag)"`                     // CFMR_VALIDATOR_EMPTYTAG
+	OversizeString   ValidationAction `default:"fix" desc:"Action for tags and fields longer than MaxStringLength (fix truncates them)"`           // CFMR_VALIDATOR_OVERSIZESTRING
+	MaxStringLength  int              `default:"65536" desc:"Maximum length in bytes of tags and string fields"`                                   // CFMR_VALIDATOR_MAXSTRINGLENGTH
+	InvalidTimestamp ValidationAction `default:"fix" desc:"Action for timestamps outside of MaxPast and MaxFuture (fix uses the current time)"`    // CFMR_VALIDATOR_INVALIDTIMESTAMP
+	MaxPast          time.Duration    `default:"8760h" desc:"Maximum age of the points"`                                                           // CFMR_VALIDATOR_MAXPAST
+	MaxFuture        time.Duration    `default:"1h" desc:"Maximum time in the future of the points"`                                               // CFMR_VALIDATOR_MAXFUTURE
+	QuarantineSize   int              `default:"100" desc:"Number of quarantined points to keep for inspection"`                                   // CFMR_VALIDATOR_QUARANTINESIZE
+}
+
+// ValidationCallback is called for every problem found.
+type ValidationCallback func(reason string, action ValidationAction)
+
+// QuarantineFunc receives the points quarantined by the Validator.
+type QuarantineFunc func(p *Point, reason string)
+
+// Validator checks the points of the envelopes before they are written, so
+// that a single invalid value can not make the output reject a whole batch.
+type Validator struct {
+	cfg        ConfigValidator
+	cb         ValidationCallback
+	quarantine QuarantineFunc
+	now        func() time.Time
+}
+
+// NewValidator creates a Validator. The callback and quarantine function are
+// optional.
+func NewValidator(cfg ConfigValidator, cb ValidationCallback, quarantine QuarantineFunc) (*Validator, error) {
+	if cfg.InvalidTimestamp == ActionDropField {
+		return nil, errors.Errorf("validation action %q is not supported for timestamps", ActionDropField)
+	}
+	if cfg.MaxStringLength <= 0 {
+		return nil, errors.New("the maximum string length must be positive")
+	}
+	return &Validator{cfg: cfg, cb: cb, quarantine: quarantine, now: time.Now}, nil
+}
+
+// Validate validates the points of the envelope (see Transform), fixing or
+// dropping the invalid ones. It returns false if no points are left.
+func (v *Validator) Validate(e *Envelope) bool {
+	ps := e.Points()
+	valid := ps[:0]
+	for _, p := range ps {
+		if v.validatePoint(p) {
+			valid = append(valid, p)
+		}
+	}
+	e.Output = valid
+	return len(valid) > 0
+}
+
+// validatePoint fixes the point in place; it returns false if it is dropped.
+func (v *Validator) validatePoint(p *Point) bool {
+	for k, val := range p.Fields {
+		var reason string
+		var action ValidationAction
+		switch val := val.(type) {
+		case float64:
+			if math.IsNaN(val) || math.IsInf(val, 0) {
+				reason, action = ReasonNonFinite, v.cfg.NonFinite
+				if action == ActionFix {
+					p.Fields[k] = finite(val)
+				}
+			}
+		case string:
+			if len(val) > v.cfg.MaxStringLength {
+				reason, action = ReasonOversizeString, v.cfg.OversizeString
+				if action == ActionFix {
+					p.Fields[k] = truncate(val, v.cfg.MaxStringLength)
+				}
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		if !v.act(p, reason, action) {
+			return false
+		}
+		if action == ActionDropField {
+			delete(p.Fields, k)
+		}
+	}
+
+	for k, val := range p.Tags {
+		var reason string
+		var action ValidationAction
+		switch {
+		case k == "" || strings.TrimSpace(val) == "":
+			reason, action = ReasonEmptyTag, v.cfg.EmptyTag
+			if action == ActionFix {
+				delete(p.Tags, k)
+			}
+		case len(val) > v.cfg.MaxStringLength:
+			reason, action = ReasonOversizeString, v.cfg.OversizeString
+			if action == ActionFix {
+				p.Tags[k] = truncate(val, v.cfg.MaxStringLength)
+			}
+		default:
+			continue
+		}
+		if !v.act(p, reason, action) {
+			return false
+		}
+		if action == ActionDropField {
+			delete(p.Tags, k)
+		}
+	}
+
+	now := v.now()
+	if p.Time.Before(now.Add(-v.cfg.MaxPast)) || p.Time.After(now.Add(v.cfg.MaxFuture)) {
+		if !v.act(p, ReasonInvalidTimestamp, v.cfg.InvalidTimestamp) {
+			return false
+		}
+		p.Time = now
+	}
+
+	if len(p.Fields) == 0 {
+		// all fields were dropped
+		return v.act(p, ReasonNoFields, ActionDropPoint)
+	}
+	return true
+}
+
+// act reports the problem and returns false if the point must be dropped.
+func (v *Validator) act(p *Point, reason string, action ValidationAction) bool {
+	if v.cb != nil {
+		v.cb(reason, action)
+	}
+	switch action {
+	case ActionDropPoint:
+		return false
+	case ActionQuarantine:
+		if v.quarantine != nil {
+			v.quarantine(p, reason)
+		}
+		return false
+	}
+	return true
+}
+
+func finite(f float64) float64 {
+	switch {
+	case math.IsInf(f, 1):
+		return math.MaxFloat64
+	case math.IsInf(f, -1):
+		return -math.MaxFloat64
+	}
+	return 0
+}
+
+// truncate truncates s to at most max bytes, without splitting characters.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
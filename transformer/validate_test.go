@@ -0,0 +1,235 @@
+package transformer
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidationActionDecode(t *testing.T) {
+	var a ValidationAction
+	if err := a.Decode("quarantine"); err != nil || a != ActionQuarantine {
+		t.Fatalf("TestValidationActionDecode: got %q, %v", a, err)
+	}
+	if err := a.Decode("ignore"); err == nil {
+		t.Fatal("TestValidationActionDecode: expected an error for an unknown action")
+	}
+}
+
+func TestNewValidator(t *testing.T) {
+	cfg := ConfigValidator{MaxStringLength: 10, InvalidTimestamp: ActionDropField}
+	if _, err := NewValidator(cfg, nil, nil); err == nil {
+		t.Fatal("TestNewValidator: expected an error dropping timestamps")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	cfg := ConfigValidator{
+		NonFinite:        ActionDropField,
+		EmptyTag:         ActionFix,
+		OversizeString:   ActionFix,
+		MaxStringLength:  4,
+		InvalidTimestamp: ActionFix,
+		MaxPast:          time.Hour,
+		MaxFuture:        time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		cfg         func(*ConfigValidator)
+		tags        map[string]string
+		fields      map[string]interface{}
+		time        time.Time
+		wantTags    map[string]string
+		wantFields  map[string]interface{}
+		wantTime    time.Time
+		wantReasons []string
+		wantQuarant bool
+	}{
+		{
+			"valid",
+			nil,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5, "msg": "ok"},
+			now,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5, "msg": "ok"},
+			now,
+			nil,
+			false,
+		},
+		{
+			"drop non-finite field",
+			nil,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5, "memory_pct": math.NaN()},
+			now,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5},
+			now,
+			[]string{ReasonNonFinite},
+			false,
+		},
+		{
+			"fix non-finite fields",
+			func(c *ConfigValidator) { c.NonFinite = ActionFix },
+			map[string]string{"app": "app"},
+			map[string]interface{}{"nan": math.NaN(), "inf": math.Inf(1), "-inf": math.Inf(-1)},
+			now,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"nan": 0.0, "inf": math.MaxFloat64, "-inf": -math.MaxFloat64},
+			now,
+			[]string{ReasonNonFinite, ReasonNonFinite, ReasonNonFinite},
+			false,
+		},
+		{
+			"no fields left",
+			nil,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"memory_pct": math.Inf(1)},
+			now,
+			nil,
+			nil,
+			time.Time{},
+			[]string{ReasonNonFinite, ReasonNoFields},
+			false,
+		},
+		{
+			"quarantine non-finite",
+			func(c *ConfigValidator) { c.NonFinite = ActionQuarantine },
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5, "memory_pct": math.NaN()},
+			now,
+			nil,
+			nil,
+			time.Time{},
+			[]string{ReasonNonFinite},
+			true,
+		},
+		{
+			"fix empty tag and oversize strings",
+			nil,
+			map[string]string{"app": "application", "level": " "},
+			map[string]interface{}{"msg": "héé"},
+			now,
+			map[string]string{"app": "appl"},
+			map[string]interface{}{"msg": "hé"},
+			now,
+			[]string{ReasonEmptyTag, ReasonOversizeString, ReasonOversizeString},
+			false,
+		},
+		{
+			"drop oversize tag",
+			func(c *ConfigValidator) { c.OversizeString = ActionDropField },
+			map[string]string{"app": "app", "path": "/long"},
+			map[string]interface{}{"cpu": 0.5},
+			now,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5},
+			now,
+			[]string{ReasonOversizeString},
+			false,
+		},
+		{
+			"fix old timestamp",
+			nil,
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5},
+			time.Unix(0, 0),
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5},
+			now,
+			[]string{ReasonInvalidTimestamp},
+			false,
+		},
+		{
+			"drop future timestamp",
+			func(c *ConfigValidator) { c.InvalidTimestamp = ActionDropPoint },
+			map[string]string{"app": "app"},
+			map[string]interface{}{"cpu": 0.5},
+			now.Add(time.Hour),
+			nil,
+			nil,
+			time.Time{},
+			[]string{ReasonInvalidTimestamp},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		c := cfg
+		if test.cfg != nil {
+			test.cfg(&c)
+		}
+		var reasons []string
+		quarantined := false
+		v, err := NewValidator(c, func(reason string, action ValidationAction) {
+			reasons = append(reasons, reason)
+		}, func(p *Point, reason string) {
+			quarantined = true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.now = func() time.Time { return now }
+
+		p, err := NewPoint("test", test.tags, test.fields, test.time, KindGauge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := &Envelope{Output: []*Point{p}}
+		ok := v.Validate(env)
+
+		if quarantined != test.wantQuarant {
+			t.Fatalf("TestValidate %s: quarantined = %v", test.name, quarantined)
+		}
+		if !sameReasons(reasons, test.wantReasons) {
+			t.Fatalf("TestValidate %s: expected reasons %v got %v", test.name, test.wantReasons, reasons)
+		}
+		if test.wantFields == nil {
+			if ok || len(env.Points()) != 0 {
+				t.Fatalf("TestValidate %s: expected the point to be dropped, got %+v", test.name, env.Points())
+			}
+			continue
+		}
+		if !ok || len(env.Points()) != 1 {
+			t.Fatalf("TestValidate %s: expected one point, got %+v", test.name, env.Points())
+		}
+		want := &Point{Name: "test", Tags: test.wantTags, Fields: test.wantFields, Time: test.wantTime, Kind: KindGauge}
+		if got := env.Points()[0]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("TestValidate %s: expected %+v got %+v", test.name, want, got)
+		}
+	}
+}
+
+func sameReasons(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	count := make(map[string]int)
+	for _, r := range got {
+		count[r]++
+	}
+	for _, r := range want {
+		count[r]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTruncate(t *testing.T) {
+	s := strings.Repeat("é", 3) // 6 bytes
+	if got := truncate(s, 5); got != "éé" {
+		t.Fatalf("TestTruncate: got %q", got)
+	}
+	if got := truncate(s, 6); got != s {
+		t.Fatalf("TestTruncate: got %q", got)
+	}
+}